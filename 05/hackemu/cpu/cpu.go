@@ -0,0 +1,163 @@
+package cpu
+
+import "fmt"
+
+const (
+	ROMSize = 32768
+	RAMSize = 32768
+	SCREEN  = 16384
+	KBD     = 24576
+)
+
+// 直前の Step での M への書き込み
+type Write struct {
+	Addr  uint16
+	Old   int16
+	Value int16
+}
+
+type CPU struct {
+	ROM       [ROMSize]uint16
+	RAM       [RAMSize]int16
+	A         int16
+	D         int16
+	PC        uint16
+	Cycles    int
+	Wrote     bool
+	LastWrite Write
+}
+
+func New() *CPU {
+	return &CPU{}
+}
+
+func (c *CPU) LoadWords(words []uint16) error {
+	if len(words) > ROMSize {
+		return fmt.Errorf("program too large: %d words", len(words))
+	}
+
+	c.ROM = [ROMSize]uint16{}
+	copy(c.ROM[:], words)
+	c.Reset()
+	return nil
+}
+
+func (c *CPU) Reset() {
+	c.PC = 0
+	c.Cycles = 0
+	c.Wrote = false
+}
+
+func (c *CPU) M() int16 {
+	return c.RAM[c.address()]
+}
+
+func (c *CPU) address() uint16 {
+	return uint16(c.A) & 0x7fff
+}
+
+func IsCInstruction(w uint16) bool {
+	return w&0x8000 != 0
+}
+
+func alu(x, y int16, ctrl uint16) int16 {
+	// zx, nx, zy, ny, f, no
+	if ctrl&0x20 != 0 {
+		x = 0
+	}
+	if ctrl&0x10 != 0 {
+		x = ^x
+	}
+	if ctrl&0x08 != 0 {
+		y = 0
+	}
+	if ctrl&0x04 != 0 {
+		y = ^y
+	}
+
+	var out int16
+	if ctrl&0x02 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+
+	if ctrl&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+func jump(out int16, bits uint16) bool {
+	switch {
+	case out < 0:
+		return bits&0x4 != 0
+	case out == 0:
+		return bits&0x2 != 0
+	default:
+		return bits&0x1 != 0
+	}
+}
+
+func (c *CPU) Step() {
+	w := c.ROM[c.PC&0x7fff]
+	c.Cycles++
+	c.Wrote = false
+
+	if !IsCInstruction(w) {
+		c.A = int16(w)
+		c.PC++
+		return
+	}
+
+	y := c.A
+	if w&0x1000 != 0 {
+		y = c.M()
+	}
+	out := alu(c.D, y, (w>>6)&0x3f)
+
+	// M への書き込み先とジャンプ先は更新前の A を使う
+	addr := c.address()
+	target := uint16(c.A)
+
+	if w&0x0008 != 0 {
+		c.LastWrite = Write{addr, c.RAM[addr], out}
+		c.Wrote = true
+		c.RAM[addr] = out
+	}
+	if w&0x0010 != 0 {
+		c.D = out
+	}
+	if w&0x0020 != 0 {
+		c.A = out
+	}
+
+	if jump(out, w&0x7) {
+		c.PC = target & 0x7fff
+	} else {
+		c.PC++
+	}
+}
+
+// (END) @END 0;JMP の無限ループに入ったかどうか
+func (c *CPU) Halted() bool {
+	pc := c.PC & 0x7fff
+	w := c.ROM[pc]
+	if IsCInstruction(w) || w != pc || pc+1 >= ROMSize {
+		return false
+	}
+
+	next := c.ROM[pc+1]
+	return IsCInstruction(next) && next&0x0038 == 0 && next&0x7 == 0x7
+}
+
+func (c *CPU) Run(maxCycles int) bool {
+	for i := 0; maxCycles < 0 || i < maxCycles; i++ {
+		if c.Halted() {
+			return true
+		}
+		c.Step()
+	}
+
+	return c.Halted()
+}
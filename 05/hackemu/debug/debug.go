@@ -0,0 +1,167 @@
+package debug
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"hackemu/cpu"
+	"hackemu/program"
+)
+
+const maxFrames = 64
+
+type Breakpoint struct {
+	ID    int
+	Addr  int
+	Watch bool
+}
+
+type Debugger struct {
+	CPU    *cpu.CPU
+	Syms   *program.Symbols
+	points []Breakpoint
+	nextID int
+
+	interrupted atomic.Bool
+}
+
+func New(c *cpu.CPU, syms *program.Symbols) *Debugger {
+	return &Debugger{CPU: c, Syms: syms, nextID: 1}
+}
+
+func (d *Debugger) add(addr int, watch bool) Breakpoint {
+	bp := Breakpoint{d.nextID, addr, watch}
+	d.nextID++
+	d.points = append(d.points, bp)
+	return bp
+}
+
+func (d *Debugger) Break(addr int) Breakpoint {
+	return d.add(addr, false)
+}
+
+func (d *Debugger) Watch(addr int) Breakpoint {
+	return d.add(addr, true)
+}
+
+func (d *Debugger) Delete(id int) bool {
+	n := len(d.points)
+	d.points = slices.DeleteFunc(d.points, func(bp Breakpoint) bool {
+		return bp.ID == id
+	})
+	return len(d.points) != n
+}
+
+func (d *Debugger) Points() []Breakpoint {
+	return d.points
+}
+
+// 別の goroutine (シグナルハンドラ) から実行を止める
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// 1 命令実行して, 止まるべき理由があればそれを返す
+func (d *Debugger) step() string {
+	c := d.CPU
+	c.Step()
+
+	for _, bp := range d.points {
+		switch {
+		case bp.Watch && c.Wrote && int(c.LastWrite.Addr) == bp.Addr:
+			w := c.LastWrite
+			return fmt.Sprintf(
+				"watchpoint %d: %s: %d -> %d",
+				bp.ID, d.RAMName(bp.Addr), w.Old, w.Value,
+			)
+		case !bp.Watch && int(c.PC) == bp.Addr:
+			return fmt.Sprintf("breakpoint %d at %s", bp.ID, d.Syms.Describe(bp.Addr))
+		}
+	}
+	return ""
+}
+
+// n 命令実行する. ブレークポイントなどで止まったらその理由を返す
+func (d *Debugger) Step(n int) string {
+	for range n {
+		if d.CPU.Halted() {
+			return "halted"
+		}
+		if reason := d.step(); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// until が 0 以上ならその番地に着いたところでも止まる
+func (d *Debugger) Continue(until int) string {
+	d.interrupted.Store(false)
+	for {
+		if d.CPU.Halted() {
+			return "halted"
+		}
+		if reason := d.step(); reason != "" {
+			return reason
+		}
+		if int(d.CPU.PC) == until {
+			return "reached " + d.Syms.Describe(until)
+		}
+		if d.interrupted.Load() {
+			return "interrupted"
+		}
+	}
+}
+
+// "RAM[256]" や "SP (RAM[0])" のような表示名
+func (d *Debugger) RAMName(addr int) string {
+	if name := d.Syms.VarName(addr); name != "" {
+		return fmt.Sprintf("%s (RAM[%d])", name, addr)
+	}
+	return fmt.Sprintf("RAM[%d]", addr)
+}
+
+type Frame struct {
+	Function string
+	PC       int
+	LCL      int
+	ARG      int
+	// LCL と ARG の間隔から求めた引数. 求められなければ nil
+	Args []int16
+}
+
+func (d *Debugger) frame(pc, lcl, arg int) Frame {
+	f := Frame{PC: pc, LCL: lcl, ARG: arg, Function: "?"}
+	if fn, ok := d.Syms.Function(pc); ok {
+		f.Function = fn.Name
+	}
+
+	// ARG の後ろに引数, その後ろに戻り先, LCL, ARG, THIS, THAT の 5 ワードが積まれる
+	if n := lcl - 5 - arg; 0 <= n && n <= 16 && arg >= 0 && lcl < cpu.RAMSize {
+		f.Args = d.CPU.RAM[arg : arg+n]
+	}
+	return f
+}
+
+// CodeWriter.WriteCall が積むフレームを LCL からたどる
+func (d *Debugger) Backtrace() []Frame {
+	ram := &d.CPU.RAM
+	lcl, arg := int(ram[1]), int(ram[2])
+	frames := []Frame{d.frame(int(d.CPU.PC), lcl, arg)}
+
+	for len(frames) < maxFrames && 5 <= lcl && lcl < cpu.RAMSize {
+		ret := int(ram[lcl-5])
+		if ret <= 0 || ret >= len(d.CPU.ROM) {
+			break
+		}
+		lcl, arg = int(ram[lcl-4]), int(ram[lcl-3])
+		// ブートストラップからの呼び出しでは LCL が初期化されていない
+		if lcl < 5 {
+			break
+		}
+		frames = append(frames, d.frame(ret, lcl, arg))
+	}
+
+	return frames
+}
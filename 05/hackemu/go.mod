@@ -0,0 +1,7 @@
+module hackemu
+
+go 1.24.0
+
+require assembler v0.0.0
+
+replace assembler => ../../06/assembler
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"assembler/disasm"
+	"hackemu/cpu"
+	"hackemu/debug"
+	"hackemu/program"
+)
+
+const help = `commands:
+  b, break <addr|label>    set a breakpoint on a ROM address
+  w, watch <addr|name>     stop when a RAM address is written
+  d, delete <id>           delete a breakpoint or watchpoint
+  i, info                  list breakpoints and watchpoints
+  s, step [n]              execute n instructions (default 1)
+  c, continue              run until a breakpoint, watchpoint or halt
+  u, until <addr|label>    run to a ROM address
+  r, regs                  show registers and the VM pointers
+  x <addr|name> [n]        show n RAM words (default 1)
+  l, list [addr|label] [n] disassemble n instructions (default 10)
+  bt, backtrace            show the VM call stack
+  reset                    restart the program with RAM cleared
+  q, quit                  exit
+An empty line repeats the previous command.`
+
+type session struct {
+	d *debug.Debugger
+}
+
+func parseNumber(s string) (int, bool) {
+	n, err := strconv.ParseInt(s, 0, 32)
+	return int(n), err == nil
+}
+
+func (s *session) romAddr(arg string) (int, error) {
+	if n, ok := parseNumber(arg); ok {
+		if n < 0 || n >= cpu.ROMSize {
+			return 0, fmt.Errorf("ROM address out of range: %s", arg)
+		}
+		return n, nil
+	}
+	if addr, ok := s.d.Syms.Label(arg); ok {
+		return addr, nil
+	}
+	return 0, fmt.Errorf("unknown label: %s", arg)
+}
+
+func (s *session) ramAddr(arg string) (int, error) {
+	if n, ok := parseNumber(arg); ok {
+		if n < 0 || n >= cpu.RAMSize {
+			return 0, fmt.Errorf("RAM address out of range: %s", arg)
+		}
+		return n, nil
+	}
+	if addr, ok := s.d.Syms.Var(arg); ok {
+		return addr, nil
+	}
+	return 0, fmt.Errorf("unknown variable: %s", arg)
+}
+
+func (s *session) instruction(addr int) string {
+	return fmt.Sprintf(
+		"%5d  %-20s %s",
+		addr, s.d.Syms.Describe(addr), disasm.Format(s.d.CPU.ROM[addr]),
+	)
+}
+
+func (s *session) stopped(reason string) {
+	if reason != "" {
+		fmt.Println(reason)
+	}
+	fmt.Println(s.instruction(int(s.d.CPU.PC)))
+}
+
+func (s *session) regs() {
+	c := s.d.CPU
+	fmt.Printf("PC   %d (%s)\n", c.PC, s.d.Syms.Describe(int(c.PC)))
+	fmt.Printf("A    %d\nD    %d\nM    %d\n", c.A, c.D, c.M())
+	for i, name := range []string{"SP", "LCL", "ARG", "THIS", "THAT"} {
+		fmt.Printf("%-4s %d\n", name, c.RAM[i])
+	}
+	for i := 13; i < 16; i++ {
+		fmt.Printf("R%-3d %d\n", i, c.RAM[i])
+	}
+	fmt.Printf("cycles %d\n", c.Cycles)
+}
+
+func (s *session) backtrace() {
+	for i, f := range s.d.Backtrace() {
+		fmt.Printf("#%-2d %s at %s", i, f.Function, s.d.Syms.Describe(f.PC))
+		if f.Args != nil {
+			args := make([]string, len(f.Args))
+			for j, a := range f.Args {
+				args[j] = strconv.Itoa(int(a))
+			}
+			fmt.Printf(" (%s)", strings.Join(args, ", "))
+		}
+		fmt.Printf("  LCL=%d ARG=%d\n", f.LCL, f.ARG)
+	}
+}
+
+func (s *session) count(args []string, i, def int) (int, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	n, ok := parseNumber(args[i])
+	if !ok || n < 1 {
+		return 0, fmt.Errorf("invalid count: %s", args[i])
+	}
+	return n, nil
+}
+
+func (s *session) exec(args []string) (bool, error) {
+	c := s.d.CPU
+
+	switch args[0] {
+	case "b", "break", "w", "watch", "u", "until", "d", "delete":
+		if len(args) < 2 {
+			return false, fmt.Errorf("%s: missing argument", args[0])
+		}
+	}
+
+	switch args[0] {
+	case "b", "break":
+		addr, err := s.romAddr(args[1])
+		if err != nil {
+			return false, err
+		}
+		bp := s.d.Break(addr)
+		fmt.Printf("breakpoint %d at %s\n", bp.ID, s.d.Syms.Describe(addr))
+
+	case "w", "watch":
+		addr, err := s.ramAddr(args[1])
+		if err != nil {
+			return false, err
+		}
+		bp := s.d.Watch(addr)
+		fmt.Printf("watchpoint %d on %s\n", bp.ID, s.d.RAMName(addr))
+
+	case "d", "delete":
+		id, ok := parseNumber(args[1])
+		if !ok || !s.d.Delete(id) {
+			return false, fmt.Errorf("no breakpoint %s", args[1])
+		}
+
+	case "i", "info":
+		for _, bp := range s.d.Points() {
+			if bp.Watch {
+				fmt.Printf("%3d  watch  %s\n", bp.ID, s.d.RAMName(bp.Addr))
+			} else {
+				fmt.Printf("%3d  break  %s\n", bp.ID, s.d.Syms.Describe(bp.Addr))
+			}
+		}
+
+	case "s", "step":
+		n, err := s.count(args, 1, 1)
+		if err != nil {
+			return false, err
+		}
+		s.stopped(s.d.Step(n))
+
+	case "c", "continue":
+		s.stopped(s.d.Continue(-1))
+
+	case "u", "until":
+		addr, err := s.romAddr(args[1])
+		if err != nil {
+			return false, err
+		}
+		s.stopped(s.d.Continue(addr))
+
+	case "r", "regs":
+		s.regs()
+
+	case "x":
+		if len(args) < 2 {
+			return false, fmt.Errorf("x: missing argument")
+		}
+		addr, err := s.ramAddr(args[1])
+		if err != nil {
+			return false, err
+		}
+		n, err := s.count(args, 2, 1)
+		if err != nil {
+			return false, err
+		}
+		for i := addr; i < min(addr+n, cpu.RAMSize); i++ {
+			fmt.Printf("%-24s %d\n", s.d.RAMName(i), c.RAM[i])
+		}
+
+	case "l", "list":
+		addr := int(c.PC)
+		if len(args) > 1 {
+			a, err := s.romAddr(args[1])
+			if err != nil {
+				return false, err
+			}
+			addr = a
+		}
+		n, err := s.count(args, 2, 10)
+		if err != nil {
+			return false, err
+		}
+		for i := addr; i < min(addr+n, cpu.ROMSize); i++ {
+			mark := " "
+			if i == int(c.PC) {
+				mark = ">"
+			}
+			fmt.Println(mark + s.instruction(i))
+		}
+
+	case "bt", "backtrace":
+		s.backtrace()
+
+	case "reset":
+		c.RAM = [cpu.RAMSize]int16{}
+		c.A, c.D = 0, 0
+		c.Reset()
+		s.stopped("")
+
+	case "h", "help":
+		fmt.Println(help)
+
+	case "q", "quit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command: %s (try help)", args[0])
+	}
+
+	return false, nil
+}
+
+func main() {
+	symPath := flag.String("sym", "", "symbol file (default: <file>.sym if present)")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Panic("No file specified")
+	}
+
+	prog, err := program.Load(flag.Arg(0), *symPath)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	c := cpu.New()
+	if err := c.LoadWords(prog.Words); err != nil {
+		log.Panic(err)
+	}
+	s := &session{d: debug.New(c, prog.Symbols)}
+
+	// 実行中の Ctrl-C はプログラムを止めるだけにする
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		for range sig {
+			s.d.Interrupt()
+		}
+	}()
+
+	fmt.Printf("%s: %d words, %d labels\n", flag.Arg(0), len(prog.Words), len(prog.Symbols.Labels()))
+	s.stopped("")
+
+	sc := bufio.NewScanner(os.Stdin)
+	var last []string
+	for {
+		fmt.Print("(hackdbg) ")
+		if !sc.Scan() {
+			fmt.Println()
+			return
+		}
+
+		args := strings.Fields(sc.Text())
+		if len(args) == 0 {
+			if last == nil {
+				continue
+			}
+			args = last
+		}
+		last = args
+
+		quit, err := s.exec(args)
+		if err != nil {
+			fmt.Println(err)
+		}
+		if quit {
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"assembler/disasm"
+	"hackemu/profile"
+	"hackemu/program"
+	"hackemu/trace"
+)
+
+func main() {
+	symPath := flag.String("sym", "", "symbol file (default: <file>.sym if present)")
+	top := flag.Int("top", 20, "number of ROM addresses to show")
+	dump := flag.Bool("dump", false, "print the trace as text instead of profiling")
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		log.Panic("usage: hackprof [flags] <program.hack|program.asm> <trace>")
+	}
+
+	prog, err := program.Load(flag.Arg(0), *symPath)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	f, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	tr, err := trace.NewReader(f)
+	if err != nil {
+		log.Panic(flag.Arg(1), ": ", err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	p := profile.New()
+	for cycle := 1; ; cycle++ {
+		rec, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Panic(flag.Arg(1), ": ", err)
+		}
+
+		if !*dump {
+			p.Add(rec.PC)
+			continue
+		}
+
+		fmt.Fprintf(
+			out, "%8d  %5d  %-24s  %-12s  A=%-6d D=%-6d",
+			cycle, rec.PC, prog.Symbols.Describe(int(rec.PC)), disasm.Format(rec.Word), rec.A, rec.D,
+		)
+		if rec.Wrote {
+			fmt.Fprintf(out, "  RAM[%d]=%d", rec.Addr, rec.Value)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if !*dump {
+		if err := profile.Write(out, p, prog.Symbols, prog.Words, *top); err != nil {
+			log.Panic(err)
+		}
+	}
+}
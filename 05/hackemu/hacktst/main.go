@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"hackemu/tst"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "print echo messages")
+	noOutput := flag.Bool("n", false, "do not write output files")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Panic("usage: hacktst [flags] <file.tst|dir|dir/...>...")
+	}
+
+	paths, err := tst.Collect(flag.Args(), ".tst")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var echo io.Writer
+	if *verbose {
+		echo = os.Stdout
+	}
+
+	failed := 0
+	for _, path := range paths {
+		r := tst.NewRunner(tst.NewCPUTarget())
+		r.Echo = echo
+		r.WriteOutput = !*noOutput
+
+		if err := r.RunFile(path); err != nil {
+			fmt.Printf("FAIL  %s\n      %s\n", path, strings.ReplaceAll(err.Error(), "\n", "\n      "))
+			failed++
+			continue
+		}
+		fmt.Printf("PASS  %s\n", path)
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", len(paths)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,72 @@
+package keyboard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hack の文字セットで印字できない文字のキーコード
+const (
+	NEWLINE   = 128
+	BACKSPACE = 129
+	LEFT      = 130
+	UP        = 131
+	RIGHT     = 132
+	DOWN      = 133
+	HOME      = 134
+	END       = 135
+	PAGEUP    = 136
+	PAGEDOWN  = 137
+	INSERT    = 138
+	DELETE    = 139
+	ESC       = 140
+	F1        = 141
+	F12       = 152
+)
+
+var names = map[string]int16{
+	"NONE":      0,
+	"RELEASE":   0,
+	"SPACE":     ' ',
+	"NEWLINE":   NEWLINE,
+	"ENTER":     NEWLINE,
+	"BACKSPACE": BACKSPACE,
+	"LEFT":      LEFT,
+	"UP":        UP,
+	"RIGHT":     RIGHT,
+	"DOWN":      DOWN,
+	"HOME":      HOME,
+	"END":       END,
+	"PAGEUP":    PAGEUP,
+	"PAGEDOWN":  PAGEDOWN,
+	"INSERT":    INSERT,
+	"DELETE":    DELETE,
+	"ESC":       ESC,
+}
+
+func init() {
+	for i := range F12 - F1 + 1 {
+		names["F"+strconv.Itoa(i+1)] = int16(F1 + i)
+	}
+}
+
+// "LEFT", "F5", "'a'", "65" のいずれかの形式
+func ParseKey(s string) (int16, error) {
+	if len(s) == 3 && s[0] == '\'' && s[2] == '\'' {
+		if s[1] < 32 || s[1] > 126 {
+			return 0, fmt.Errorf("invalid key: %s", s)
+		}
+		return int16(s[1]), nil
+	}
+
+	if k, ok := names[strings.ToUpper(s)]; ok {
+		return k, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 16)
+	if err != nil || n < 0 || n > F12 {
+		return 0, fmt.Errorf("invalid key: %s", s)
+	}
+	return int16(n), nil
+}
@@ -0,0 +1,106 @@
+package keyboard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"hackemu/cpu"
+)
+
+type Event struct {
+	Cycle int
+	Key   int16
+}
+
+// 押されたキーは次のイベントまで押されたままになる
+type Script struct {
+	events []Event
+	next   int
+}
+
+func stripComment(s string) string {
+	if i := strings.Index(s, "//"); i != -1 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "#"); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// 時刻はサイクル数, または "12f" のようにフレーム数で書く
+func parseTime(s string, frame int) (int, error) {
+	mul := 1
+	if strings.HasSuffix(s, "f") {
+		s, mul = s[:len(s)-1], frame
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return n * mul, nil
+}
+
+// 1 行に 1 つ "<時刻> <キー>" を書く
+//
+//	1000  LEFT
+//	5f    'a'
+//	6f    release
+func ParseScript(r io.Reader, frame int) (*Script, error) {
+	var events []Event
+
+	sc := bufio.NewScanner(r)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := stripComment(sc.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected <time> <key>: %s", lineNum, line)
+		}
+
+		cycle, err := parseTime(fields[0], frame)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		key, err := ParseKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		events = append(events, Event{cycle, key})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(events, func(x, y Event) int {
+		return x.Cycle - y.Cycle
+	})
+	return &Script{events: events}, nil
+}
+
+// c.Cycles までに起きたイベントを KBD に反映する
+func (s *Script) Apply(c *cpu.CPU) {
+	for s.next < len(s.events) && s.events[s.next].Cycle <= c.Cycles {
+		c.RAM[cpu.KBD] = s.events[s.next].Key
+		s.next++
+	}
+}
+
+// 次のイベントのサイクル数. もうなければ -1
+func (s *Script) NextCycle() int {
+	if s.next >= len(s.events) {
+		return -1
+	}
+	return s.events[s.next].Cycle
+}
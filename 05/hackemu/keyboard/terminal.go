@@ -0,0 +1,132 @@
+package keyboard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"hackemu/cpu"
+)
+
+// 端末からはキーを離したことがわからないので, 押してから hold サイクルで離す
+type Terminal struct {
+	keys    chan int16
+	quit    chan struct{}
+	hold    int
+	release int
+	saved   string
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %v (is stdin a terminal?)", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// 標準入力を raw モードにしてキー入力を読み始める (画面の改行はそのまま). 終わったら Close で元に戻す
+func OpenTerminal(hold int) (*Terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo", "opost"); err != nil {
+		return nil, err
+	}
+
+	t := &Terminal{
+		keys:  make(chan int16, 64),
+		quit:  make(chan struct{}),
+		hold:  hold,
+		saved: saved,
+	}
+	go t.read(bufio.NewReader(os.Stdin))
+	return t, nil
+}
+
+func (t *Terminal) Close() error {
+	_, err := stty(t.saved)
+	return err
+}
+
+// Ctrl-C で閉じられる
+func (t *Terminal) Quit() <-chan struct{} {
+	return t.quit
+}
+
+var escapes = map[string]int16{
+	"[A": UP, "[B": DOWN, "[C": RIGHT, "[D": LEFT,
+	"[H": HOME, "[F": END, "OH": HOME, "OF": END,
+	"[1~": HOME, "[4~": END, "[2~": INSERT, "[3~": DELETE,
+	"[5~": PAGEUP, "[6~": PAGEDOWN,
+	"OP": F1, "OQ": F1 + 1, "OR": F1 + 2, "OS": F1 + 3,
+	"[15~": F1 + 4, "[17~": F1 + 5, "[18~": F1 + 6, "[19~": F1 + 7,
+	"[20~": F1 + 8, "[21~": F1 + 9, "[23~": F1 + 10, "[24~": F12,
+}
+
+func (t *Terminal) read(r *bufio.Reader) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				close(t.quit)
+			}
+			return
+		}
+
+		switch {
+		case b == 0x03:
+			close(t.quit)
+			return
+		case b == '\r' || b == '\n':
+			t.keys <- NEWLINE
+		case b == 0x7f || b == 0x08:
+			t.keys <- BACKSPACE
+		case b == 0x1b:
+			t.keys <- t.escape(r)
+		case 32 <= b && b <= 126:
+			t.keys <- int16(b)
+		}
+	}
+}
+
+// ESC に続くシーケンスを読む. 続きがなければ ESC キーそのもの
+func (t *Terminal) escape(r *bufio.Reader) int16 {
+	if r.Buffered() == 0 {
+		return ESC
+	}
+
+	var seq strings.Builder
+	for r.Buffered() > 0 {
+		b, _ := r.ReadByte()
+		seq.WriteByte(b)
+		if seq.Len() > 1 && (b == '~' || ('A' <= b && b <= 'Z')) {
+			break
+		}
+	}
+
+	if k, ok := escapes[seq.String()]; ok {
+		return k
+	}
+	return ESC
+}
+
+// 届いたキーを KBD に反映し, hold サイクル経ったら離す
+func (t *Terminal) Apply(c *cpu.CPU) {
+	select {
+	case k := <-t.keys:
+		c.RAM[cpu.KBD] = k
+		t.release = c.Cycles + t.hold
+	default:
+		if t.release > 0 && c.Cycles >= t.release {
+			c.RAM[cpu.KBD] = 0
+			t.release = 0
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"hackemu/cpu"
+	"hackemu/keyboard"
+	"hackemu/profile"
+	"hackemu/program"
+	"hackemu/screen"
+	"hackemu/trace"
+)
+
+type assigns []string
+
+func (a *assigns) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *assigns) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+func parseAssign(s string) (int, int16, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid assignment: %s", s)
+	}
+
+	addr, err := strconv.Atoi(k)
+	if err != nil || addr < 0 || addr >= cpu.RAMSize {
+		return 0, 0, fmt.Errorf("invalid address: %s", k)
+	}
+	n, err := strconv.ParseInt(v, 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid value: %s", v)
+	}
+
+	return addr, int16(n), nil
+}
+
+func parseRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		hi = lo
+	}
+
+	l, err := strconv.Atoi(lo)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range: %s", s)
+	}
+	h, err := strconv.Atoi(hi)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range: %s", s)
+	}
+	if !ok {
+		h++
+	}
+	if l < 0 || h > cpu.RAMSize || l > h {
+		return 0, 0, fmt.Errorf("invalid range: %s", s)
+	}
+
+	return l, h, nil
+}
+
+// hook があれば 1 命令ごとに実行前の PC と命令を渡して呼ぶ
+func run(c *cpu.CPU, n int, hook func(pc, word uint16)) bool {
+	if hook == nil {
+		return c.Run(n)
+	}
+
+	for range n {
+		if c.Halted() {
+			return true
+		}
+		pc := c.PC & 0x7fff
+		word := c.ROM[pc]
+		c.Step()
+		hook(pc, word)
+	}
+	return c.Halted()
+}
+
+func main() {
+	var sets assigns
+	cycles := flag.Int("n", 1000000, "max cycles to run (-1: until halted)")
+	dump := flag.String("ram", "0:16", "RAM range to dump (lo:hi)")
+	flag.Var(&sets, "set", "initialize RAM before running (addr=value)")
+	pngPath := flag.String("png", "", "write the final screen to a PNG file")
+	live := flag.String("screen", "", "render the screen in the terminal ("+strings.Join(screen.Modes(), ", ")+")")
+	scale := flag.Int("scale", 1, "shrink the terminal screen by this factor")
+	frame := flag.Int("frame", 100000, "cycles per frame")
+	keys := flag.String("keys", "", "drive KBD from a script of timed key events")
+	tty := flag.Bool("tty", false, "drive KBD from the terminal in raw mode")
+	hold := flag.Int("hold", 0, "cycles a terminal key stays pressed (default: one frame)")
+	symPath := flag.String("sym", "", "symbol file (default: <file>.sym if present)")
+	tracePath := flag.String("trace", "", "record every cycle to a trace file")
+	prof := flag.Bool("profile", false, "print cycles per function and the hottest ROM addresses")
+	top := flag.Int("top", 20, "number of ROM addresses shown by -profile")
+	flag.Parse()
+
+	var mode screen.Mode
+	if *live != "" {
+		m, err := screen.ParseMode(*live)
+		if err != nil {
+			log.Panic(err)
+		}
+		mode = m
+	}
+	if *frame <= 0 {
+		log.Panic("invalid frame length: ", *frame)
+	}
+
+	if flag.NArg() < 1 {
+		log.Panic("No file specified")
+	}
+
+	prog, err := program.Load(flag.Arg(0), *symPath)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	c := cpu.New()
+	if err := c.LoadWords(prog.Words); err != nil {
+		log.Panic(err)
+	}
+
+	for _, s := range sets {
+		addr, n, err := parseAssign(s)
+		if err != nil {
+			log.Panic(err)
+		}
+		c.RAM[addr] = n
+	}
+
+	lo, hi, err := parseRange(*dump)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var script *keyboard.Script
+	if *keys != "" {
+		f, err := os.Open(*keys)
+		if err != nil {
+			log.Panic(err)
+		}
+		script, err = keyboard.ParseScript(f, *frame)
+		f.Close()
+		if err != nil {
+			log.Panic(*keys, ": ", err)
+		}
+	}
+
+	var term *keyboard.Terminal
+	if *tty {
+		if *hold <= 0 {
+			*hold = *frame
+		}
+		term, err = keyboard.OpenTerminal(*hold)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer term.Close()
+	}
+
+	var tw *trace.Writer
+	if *tracePath != "" {
+		f, err := os.Create(*tracePath)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer f.Close()
+		if tw, err = trace.NewWriter(f); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	var p *profile.Profile
+	if *prof {
+		p = profile.New()
+	}
+
+	var hook func(pc, word uint16)
+	if tw != nil || p != nil {
+		hook = func(pc, word uint16) {
+			if tw != nil {
+				if err := tw.Record(c, pc, word); err != nil {
+					log.Panic(err)
+				}
+			}
+			if p != nil {
+				p.Add(pc)
+			}
+		}
+	}
+
+	halted := false
+	for !halted && (*cycles < 0 || c.Cycles < *cycles) {
+		n := *frame
+		if *cycles >= 0 {
+			n = min(n, *cycles-c.Cycles)
+		}
+
+		if script != nil {
+			script.Apply(c)
+			// 次のイベントの時刻ちょうどで止める
+			if next := script.NextCycle(); next > c.Cycles {
+				n = min(n, next-c.Cycles)
+			}
+		}
+		if term != nil {
+			select {
+			case <-term.Quit():
+				*cycles = c.Cycles
+				continue
+			default:
+			}
+			term.Apply(c)
+		}
+
+		halted = run(c, n, hook)
+
+		if mode != "" {
+			// カーソルを左上に戻して上書きする
+			fmt.Print("\x1b[H")
+			if err := screen.Render(os.Stdout, c, mode, *scale); err != nil {
+				log.Panic(err)
+			}
+		}
+	}
+	halted = c.Halted()
+
+	if tw != nil {
+		if err := tw.Flush(); err != nil {
+			log.Panic(err)
+		}
+	}
+	if p != nil {
+		if err := profile.Write(os.Stderr, p, prog.Symbols, prog.Words, *top); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if *pngPath != "" {
+		out, err := os.Create(*pngPath)
+		if err != nil {
+			log.Panic(err)
+		}
+		if err := screen.WritePNG(out, c); err != nil {
+			log.Panic(err)
+		}
+		if err := out.Close(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	fmt.Printf("cycles: %d", c.Cycles)
+	if halted {
+		fmt.Print(" (halted)")
+	}
+	fmt.Println()
+	fmt.Printf("PC: %d\nA:  %d\nD:  %d\n", c.PC, c.A, c.D)
+	for i := lo; i < hi; i++ {
+		fmt.Printf("RAM[%5d]: %d\n", i, c.RAM[i])
+	}
+}
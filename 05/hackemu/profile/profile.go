@@ -0,0 +1,108 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+
+	"assembler/disasm"
+	"assembler/report"
+	"hackemu/cpu"
+	"hackemu/program"
+)
+
+type Profile struct {
+	Counts [cpu.ROMSize]int
+	Total  int
+}
+
+func New() *Profile {
+	return &Profile{}
+}
+
+func (p *Profile) Add(pc uint16) {
+	p.Counts[pc&0x7fff]++
+	p.Total++
+}
+
+type Region struct {
+	Name   string
+	Start  int
+	Cycles int
+}
+
+// 関数 (局所的でないラベル) ごとに実行サイクル数を合計し, 多い順に返す
+func (p *Profile) Regions(syms *program.Symbols) []Region {
+	var regions []Region
+	for _, r := range report.Split(syms.Labels(), cpu.ROMSize) {
+		cycles := 0
+		for addr := r.Start; addr < r.Start+r.Size; addr++ {
+			cycles += p.Counts[addr]
+		}
+		regions = append(regions, Region{Name: r.Label, Start: r.Start, Cycles: cycles})
+	}
+
+	regions = slices.DeleteFunc(regions, func(r Region) bool {
+		return r.Cycles == 0
+	})
+	slices.SortStableFunc(regions, func(x, y Region) int {
+		return y.Cycles - x.Cycles
+	})
+	return regions
+}
+
+type Hotspot struct {
+	Addr   int
+	Cycles int
+}
+
+// 実行回数の多い ROM 番地を top 個まで返す
+func (p *Profile) Hotspots(top int) []Hotspot {
+	var spots []Hotspot
+	for addr, n := range p.Counts {
+		if n > 0 {
+			spots = append(spots, Hotspot{addr, n})
+		}
+	}
+	slices.SortStableFunc(spots, func(x, y Hotspot) int {
+		return y.Cycles - x.Cycles
+	})
+	return spots[:min(top, len(spots))]
+}
+
+func (p *Profile) percent(n int) float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(p.Total)
+}
+
+func Write(w io.Writer, p *Profile, syms *program.Symbols, rom []uint16, top int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "cycles: %d\n", p.Total)
+
+	regions := p.Regions(syms)
+	width := len("FUNCTION")
+	for _, r := range regions {
+		width = max(width, len(r.Name))
+	}
+	fmt.Fprintf(bw, "\n%-*s  %12s  %6s\n", width, "FUNCTION", "CYCLES", "%")
+	for _, r := range regions {
+		fmt.Fprintf(bw, "%-*s  %12d  %5.1f%%\n", width, r.Name, r.Cycles, p.percent(r.Cycles))
+	}
+
+	fmt.Fprintf(bw, "\n%5s  %12s  %6s  %-24s  %s\n", "ADDR", "CYCLES", "%", "LOCATION", "INSTRUCTION")
+	for _, h := range p.Hotspots(top) {
+		inst := ""
+		if h.Addr < len(rom) {
+			inst = disasm.Format(rom[h.Addr])
+		}
+		fmt.Fprintf(
+			bw, "%5d  %12d  %5.1f%%  %-24s  %s\n",
+			h.Addr, h.Cycles, p.percent(h.Cycles), syms.Describe(h.Addr), inst,
+		)
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,75 @@
+package program
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"assembler/assembler"
+	"assembler/disasm"
+	"assembler/symfile"
+)
+
+type Program struct {
+	Words   []uint16
+	Symbols *Symbols
+}
+
+func readSymbols(path string) (symfile.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return symfile.File{}, err
+	}
+	defer f.Close()
+
+	syms, err := symfile.Read(f)
+	if err != nil {
+		return symfile.File{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return syms, nil
+}
+
+// .asm ならその場でアセンブルしてシンボルも得る. .hack なら symPath,
+// 指定がなければ同じ名前の .sym があれば読む
+func Load(path, symPath string) (*Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == ".asm" {
+		prog, err := assembler.AssembleFiles(
+			[]assembler.Source{{Name: path, Data: data}},
+			assembler.Options{},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &Program{
+			Words:   prog.Words,
+			Symbols: NewSymbols(symfile.FromProgram(prog, symfile.File{})),
+		}, nil
+	}
+
+	words, err := disasm.ReadHack(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var syms symfile.File
+	if symPath == "" {
+		p := strings.TrimSuffix(path, filepath.Ext(path)) + ".sym"
+		if _, err := os.Stat(p); err == nil {
+			symPath = p
+		}
+	}
+	if symPath != "" {
+		if syms, err = readSymbols(symPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Program{Words: words, Symbols: NewSymbols(syms)}, nil
+}
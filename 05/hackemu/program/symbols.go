@@ -0,0 +1,117 @@
+package program
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"assembler/assembler"
+	"assembler/report"
+	"assembler/symfile"
+)
+
+var predefined = []assembler.Symbol{
+	{Name: "SP", Address: 0},
+	{Name: "LCL", Address: 1},
+	{Name: "ARG", Address: 2},
+	{Name: "THIS", Address: 3},
+	{Name: "THAT", Address: 4},
+	{Name: "R13", Address: 13},
+	{Name: "R14", Address: 14},
+	{Name: "R15", Address: 15},
+	{Name: "SCREEN", Address: 16384},
+	{Name: "KBD", Address: 24576},
+}
+
+type Symbols struct {
+	labels []assembler.Symbol
+	vars   []assembler.Symbol
+}
+
+func NewSymbols(f symfile.File) *Symbols {
+	s := &Symbols{
+		labels: slices.Clone(f.Labels),
+		vars:   append(slices.Clone(predefined), f.Vars...),
+	}
+	slices.SortStableFunc(s.labels, func(x, y assembler.Symbol) int {
+		return x.Address - y.Address
+	})
+	return s
+}
+
+func (s *Symbols) Labels() []assembler.Symbol {
+	return s.labels
+}
+
+func find(syms []assembler.Symbol, name string) (int, bool) {
+	for _, s := range syms {
+		if s.Name == name {
+			return s.Address, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Symbols) Label(name string) (int, bool) {
+	return find(s.labels, name)
+}
+
+func (s *Symbols) Var(name string) (int, bool) {
+	if addr, ok := find(s.vars, name); ok {
+		return addr, true
+	}
+	if len(name) > 1 && name[0] == 'R' {
+		var n int
+		if _, err := fmt.Sscanf(name, "R%d", &n); err == nil && 0 <= n && n < 16 {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// addr を含む関数 (局所的でない直前のラベル)
+func (s *Symbols) Function(addr int) (assembler.Symbol, bool) {
+	var fn assembler.Symbol
+	found := false
+	for _, l := range s.labels {
+		if l.Address > addr {
+			break
+		}
+		if !report.IsLocal(l.Name) {
+			fn, found = l, true
+		}
+	}
+	return fn, found
+}
+
+// ROM 番地を "Sys.fact+12" のような形にする
+func (s *Symbols) Describe(addr int) string {
+	var best assembler.Symbol
+	found := false
+	for _, l := range s.labels {
+		if l.Address > addr {
+			break
+		}
+		best, found = l, true
+	}
+
+	switch {
+	case !found:
+		return fmt.Sprint(addr)
+	case best.Address == addr:
+		return best.Name
+	default:
+		return fmt.Sprintf("%s+%d", best.Name, addr-best.Address)
+	}
+}
+
+// RAM 番地につけられた名前. なければ空文字列
+func (s *Symbols) VarName(addr int) string {
+	var names []string
+	for _, v := range s.vars {
+		if v.Address == addr {
+			names = append(names, v.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
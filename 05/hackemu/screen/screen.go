@@ -0,0 +1,137 @@
+package screen
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"hackemu/cpu"
+)
+
+const (
+	Width  = 512
+	Height = 256
+	// 1 行あたりのワード数
+	rowWords = Width / 16
+)
+
+type Mode string
+
+const (
+	BLOCK   Mode = "block"
+	BRAILLE Mode = "braille"
+)
+
+func Modes() []string {
+	return []string{string(BLOCK), string(BRAILLE)}
+}
+
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes() {
+		if s == m {
+			return Mode(s), nil
+		}
+	}
+	return "", fmt.Errorf("unknown screen mode: %s", s)
+}
+
+// ワードの最下位ビットが左端の画素
+func Pixel(c *cpu.CPU, x, y int) bool {
+	w := c.RAM[cpu.SCREEN+y*rowWords+x/16]
+	return w&(1<<(x%16)) != 0
+}
+
+func Image(c *cpu.CPU) *image.Paletted {
+	palette := color.Palette{color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, Width, Height), palette)
+	for y := range Height {
+		for x := range Width {
+			if Pixel(c, x, y) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	return img
+}
+
+func WritePNG(w io.Writer, c *cpu.CPU) error {
+	return png.Encode(w, Image(c))
+}
+
+// scale x scale 画素のうち 1 つでも黒なら黒とみなす
+func sample(c *cpu.CPU, x, y, scale int) bool {
+	x, y = x*scale, y*scale
+	if x >= Width || y >= Height {
+		return false
+	}
+
+	for dy := range scale {
+		for dx := range scale {
+			if x+dx < Width && y+dy < Height && Pixel(c, x+dx, y+dy) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 2x2 画素を 1 文字にする
+var quadrants = []rune(" ▘▝▀▖▌▞▛▗▚▐▜▄▙▟█")
+
+// 2x4 画素を 1 文字にする. 点の番号は Unicode の点字の並び
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+func cellSize(m Mode) (int, int) {
+	if m == BRAILLE {
+		return 2, 4
+	}
+	return 2, 2
+}
+
+// 画面を文字で描く. scale が 2 以上なら縮小する
+func Render(w io.Writer, c *cpu.CPU, m Mode, scale int) error {
+	scale = max(scale, 1)
+	cw, ch := cellSize(m)
+	width := (Width/scale + cw - 1) / cw
+	height := (Height/scale + ch - 1) / ch
+
+	bw := bufio.NewWriter(w)
+	for row := range height {
+		for col := range width {
+			x, y := col*cw, row*ch
+
+			if m == BRAILLE {
+				r := rune(0x2800)
+				for dy := range 4 {
+					for dx := range 2 {
+						if sample(c, x+dx, y+dy, scale) {
+							r |= brailleDots[dy][dx]
+						}
+					}
+				}
+				bw.WriteRune(r)
+				continue
+			}
+
+			i := 0
+			for bit, p := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				if sample(c, x+p[0], y+p[1], scale) {
+					i |= 1 << bit
+				}
+			}
+			bw.WriteRune(quadrants[i])
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
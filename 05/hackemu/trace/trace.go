@@ -0,0 +1,107 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"hackemu/cpu"
+)
+
+// ファイルの先頭 5 バイト. 続けて 1 サイクルごとに
+// PC, 命令, 実行後の A, D (各 2 バイト, リトルエンディアン) を書き,
+// M に書き込む命令ならさらに番地と値の 4 バイトを続ける
+var magic = []byte("HTRC\x01")
+
+type Record struct {
+	PC    uint16
+	Word  uint16
+	A     int16
+	D     int16
+	Wrote bool
+	Addr  uint16
+	Value int16
+}
+
+func writesM(word uint16) bool {
+	return cpu.IsCInstruction(word) && word&0x0008 != 0
+}
+
+type Writer struct {
+	bw  *bufio.Writer
+	buf [12]byte
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(magic); err != nil {
+		return nil, err
+	}
+	return &Writer{bw: bw}, nil
+}
+
+// pc と word は実行前に読んでおいた値. c は Step を終えた状態
+func (t *Writer) Record(c *cpu.CPU, pc, word uint16) error {
+	b := t.buf[:8]
+	binary.LittleEndian.PutUint16(b[0:], pc)
+	binary.LittleEndian.PutUint16(b[2:], word)
+	binary.LittleEndian.PutUint16(b[4:], uint16(c.A))
+	binary.LittleEndian.PutUint16(b[6:], uint16(c.D))
+	if writesM(word) {
+		b = t.buf[:12]
+		binary.LittleEndian.PutUint16(b[8:], c.LastWrite.Addr)
+		binary.LittleEndian.PutUint16(b[10:], uint16(c.LastWrite.Value))
+	}
+
+	_, err := t.bw.Write(b)
+	return err
+}
+
+func (t *Writer) Flush() error {
+	return t.bw.Flush()
+}
+
+type Reader struct {
+	br  *bufio.Reader
+	buf [12]byte
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil || string(head) != string(magic) {
+		return nil, fmt.Errorf("not a Hack trace file")
+	}
+	return &Reader{br: br}, nil
+}
+
+// 終わりに達したら io.EOF を返す
+func (t *Reader) Next() (Record, error) {
+	b := t.buf[:8]
+	if _, err := io.ReadFull(t.br, b); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, fmt.Errorf("truncated trace record")
+		}
+		return Record{}, err
+	}
+
+	rec := Record{
+		PC:   binary.LittleEndian.Uint16(b[0:]),
+		Word: binary.LittleEndian.Uint16(b[2:]),
+		A:    int16(binary.LittleEndian.Uint16(b[4:])),
+		D:    int16(binary.LittleEndian.Uint16(b[6:])),
+	}
+	if writesM(rec.Word) {
+		b = t.buf[8:12]
+		if _, err := io.ReadFull(t.br, b); err != nil {
+			return Record{}, fmt.Errorf("truncated trace record")
+		}
+		rec.Wrote = true
+		rec.Addr = binary.LittleEndian.Uint16(b[0:])
+		rec.Value = int16(binary.LittleEndian.Uint16(b[2:]))
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,124 @@
+package tst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"hackemu/cpu"
+	"hackemu/program"
+)
+
+// CPU エミュレータ用のスクリプト (Mult.tst など) の対象
+type CPUTarget struct {
+	CPU  *cpu.CPU
+	time int
+	// tick の後, tock の前
+	half bool
+}
+
+func NewCPUTarget() *CPUTarget {
+	return &CPUTarget{CPU: cpu.New()}
+}
+
+// .asm を渡すとアセンブルしてから読み込む. RAM はそのまま残す
+func (t *CPUTarget) Load(path string) error {
+	prog, err := program.Load(path, "")
+	if err != nil {
+		return err
+	}
+	t.time, t.half = 0, false
+	return t.CPU.LoadWords(prog.Words)
+}
+
+// "RAM[16]" -> ("RAM", 16)
+func index(name string) (string, int, bool) {
+	base, rest, ok := strings.Cut(name, "[")
+	if !ok || !strings.HasSuffix(rest, "]") {
+		return name, 0, false
+	}
+	n, err := strconv.Atoi(rest[:len(rest)-1])
+	if err != nil {
+		return name, 0, false
+	}
+	return base, n, true
+}
+
+func (t *CPUTarget) Get(name string) (Value, error) {
+	c := t.CPU
+	switch name {
+	case "A":
+		return Value{N: int(c.A)}, nil
+	case "D":
+		return Value{N: int(c.D)}, nil
+	case "PC":
+		return Value{N: int(c.PC)}, nil
+	case "time":
+		s := strconv.Itoa(t.time)
+		if t.half {
+			s += "+"
+		}
+		return Value{N: t.time, Text: s}, nil
+	}
+
+	base, i, ok := index(name)
+	switch {
+	case ok && base == "RAM" && 0 <= i && i < cpu.RAMSize:
+		return Value{N: int(c.RAM[i])}, nil
+	case ok && base == "ROM" && 0 <= i && i < cpu.ROMSize:
+		return Value{N: int(int16(c.ROM[i]))}, nil
+	}
+	return Value{}, fmt.Errorf("unknown variable: %s", name)
+}
+
+func (t *CPUTarget) Set(name string, value int) error {
+	if value < -1<<15 || value >= 1<<16 {
+		return fmt.Errorf("value out of range: %d", value)
+	}
+
+	c := t.CPU
+	switch name {
+	case "A":
+		c.A = int16(value)
+		return nil
+	case "D":
+		c.D = int16(value)
+		return nil
+	case "PC":
+		c.PC = uint16(value) & 0x7fff
+		return nil
+	}
+
+	base, i, ok := index(name)
+	switch {
+	case ok && base == "RAM" && 0 <= i && i < cpu.RAMSize:
+		c.RAM[i] = int16(value)
+		return nil
+	case ok && base == "ROM" && 0 <= i && i < cpu.ROMSize:
+		c.ROM[i] = uint16(value)
+		return nil
+	}
+	return fmt.Errorf("unknown variable: %s", name)
+}
+
+// 1 命令の実行を tick と tock の 2 つに分け, tock で状態を更新する
+func (t *CPUTarget) Exec(cmd string, args []string) (bool, error) {
+	switch cmd {
+	case "tick":
+		t.half = true
+	case "tock":
+		t.CPU.Step()
+		t.time++
+		t.half = false
+	case "ticktock":
+		t.CPU.Step()
+		t.time++
+	default:
+		return false, nil
+	}
+
+	if len(args) > 0 {
+		return true, fmt.Errorf("%s takes no arguments", cmd)
+	}
+	return true, nil
+}
@@ -0,0 +1,44 @@
+package tst
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// コマンドの引数からファイルを集める. ディレクトリからは拡張子が exts のものだけを,
+// "dir/..." なら再帰的に探す. ファイルを直接指定した場合はそのまま返す
+func Collect(args []string, exts ...string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		root, recursive := strings.CutSuffix(arg, "...")
+		root = filepath.Clean(root)
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path != root && !recursive {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && slices.Contains(exts, filepath.Ext(path)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
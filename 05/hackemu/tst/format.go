@@ -0,0 +1,112 @@
+package tst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 変数の値. Text が空でなければ文字列として出力する (time の "3+" など)
+type Value struct {
+	N    int
+	Text string
+}
+
+// output-list の 1 列. "RAM[0]%D2.6.2" は RAM[0] を左 2, 幅 6, 右 2 の 10 桁で 10 進表示する
+type column struct {
+	name  string
+	base  byte
+	padL  int
+	width int
+	padR  int
+}
+
+func parseColumn(s, def string) (column, error) {
+	name, spec, ok := strings.Cut(s, "%")
+	if !ok {
+		spec = strings.TrimPrefix(def, "%")
+	}
+	if name == "" || len(spec) < 2 || !strings.ContainsRune("BDXS", rune(spec[0])) {
+		return column{}, fmt.Errorf("invalid output format: %s", s)
+	}
+
+	parts := strings.Split(spec[1:], ".")
+	if len(parts) != 3 {
+		return column{}, fmt.Errorf("invalid output format: %s", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return column{}, fmt.Errorf("invalid output format: %s", s)
+		}
+		n[i] = v
+	}
+
+	return column{name, spec[0], n[0], n[1], n[2]}, nil
+}
+
+// 列幅の中央に名前を置く
+func (col column) header() string {
+	space := col.padL + col.width + col.padR
+	name := col.name
+	if len(name) > space {
+		name = name[:space]
+	}
+	left := (space - len(name)) / 2
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", space-left-len(name))
+}
+
+func lastN(s string, n int) string {
+	if len(s) > n {
+		return s[len(s)-n:]
+	}
+	return s
+}
+
+func (col column) format(v Value) string {
+	var s string
+	switch {
+	case v.Text != "":
+		s = v.Text
+	case col.base == 'B':
+		s = lastN(fmt.Sprintf("%016b", uint16(v.N)), col.width)
+	case col.base == 'X':
+		s = lastN(fmt.Sprintf("%04X", uint16(v.N)), col.width)
+	default:
+		s = lastN(strconv.Itoa(v.N), col.width)
+	}
+
+	// 文字列は左寄せ, 数値は右寄せ
+	if col.base == 'S' {
+		return strings.Repeat(" ", col.padL) + s + strings.Repeat(" ", col.padR+max(col.width-len(s), 0))
+	}
+	return strings.Repeat(" ", col.padL+max(col.width-len(s), 0)) + s + strings.Repeat(" ", col.padR)
+}
+
+// set の値: 10 進数, または %B, %X, %D を前に付けた数
+func ParseValue(s string) (int, error) {
+	base := 10
+	if len(s) > 2 && s[0] == '%' {
+		switch s[1] {
+		case 'B':
+			base = 2
+		case 'X':
+			base = 16
+		case 'D':
+		default:
+			return 0, fmt.Errorf("invalid value: %s", s)
+		}
+		s = s[2:]
+	}
+
+	n, err := strconv.ParseInt(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+	// 2 進と 16 進は 16 ビットの符号付きとして読む
+	if base != 10 && n >= 1<<15 && n < 1<<16 {
+		n -= 1 << 16
+	}
+	return int(n), nil
+}
@@ -0,0 +1,348 @@
+package tst
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 無限ループする while や repeat を止めるための上限
+const maxIterations = 100_000_000
+
+var ErrComparison = errors.New("comparison failure")
+
+// スクリプトの対象 (CPU エミュレータやハードウェアシミュレータ)
+type Target interface {
+	Load(path string) error
+	Get(name string) (Value, error)
+	Set(name string, value int) error
+	// tick, tock, ticktock, eval などの対象固有のコマンド. 知らなければ false を返す
+	Exec(cmd string, args []string) (bool, error)
+}
+
+// 書式を省略した output-list の列に使う書式を対象が決める場合に実装する
+type DefaultFormatter interface {
+	DefaultFormat(name string) string
+}
+
+type Runner struct {
+	Target Target
+	// echo の出力先. nil なら捨てる
+	Echo io.Writer
+	// output-file を書き出すかどうか
+	WriteOutput bool
+
+	name    string
+	dir     string
+	columns []column
+	out     bytes.Buffer
+	outName string
+	cmp     []string
+	cmpName string
+	lines   int
+}
+
+func NewRunner(t Target) *Runner {
+	return &Runner{Target: t, WriteOutput: true}
+}
+
+func (r *Runner) errorf(line int, format string, a ...any) error {
+	return &Error{r.name, line, fmt.Errorf(format, a...)}
+}
+
+// スクリプトのディレクトリからの相対パスを解決する
+func (r *Runner) Path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(r.dir, file)
+}
+
+// cmp の "*" はどの文字とも一致する
+func matches(expected, actual string) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := range len(expected) {
+		if expected[i] != '*' && expected[i] != actual[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 出力の n 行目 (1 始まり) を compare-to のファイルと比べる
+func (r *Runner) compare(line, n int, s string) error {
+	if r.cmp == nil {
+		return nil
+	}
+	if n > len(r.cmp) {
+		return r.errorf(line, "%w at line %d: %s has no more lines", ErrComparison, n, r.cmpName)
+	}
+	if want := strings.TrimRight(r.cmp[n-1], " \r"); !matches(want, strings.TrimRight(s, " ")) {
+		return r.errorf(
+			line, "%w at line %d of %s\n  expected: %s\n  actual:   %s",
+			ErrComparison, n, r.cmpName, want, s,
+		)
+	}
+	return nil
+}
+
+func (r *Runner) emit(line int, s string) error {
+	r.out.WriteString(s)
+	r.out.WriteByte('\n')
+	r.lines++
+	return r.compare(line, r.lines, s)
+}
+
+func (r *Runner) header(line int) error {
+	var b strings.Builder
+	b.WriteByte('|')
+	for _, col := range r.columns {
+		b.WriteString(col.header())
+		b.WriteByte('|')
+	}
+	return r.emit(line, b.String())
+}
+
+func (r *Runner) output(line int) error {
+	var b strings.Builder
+	b.WriteByte('|')
+	for _, col := range r.columns {
+		v, err := r.Target.Get(col.name)
+		if err != nil {
+			return r.errorf(line, "%v", err)
+		}
+		b.WriteString(col.format(v))
+		b.WriteByte('|')
+	}
+	return r.emit(line, b.String())
+}
+
+// "RAM[0] <> 0" の形の条件
+func (r *Runner) cond(c command) (bool, error) {
+	expr := strings.Join(c.args[1:], " ")
+	for _, op := range []string{"<>", "<=", ">=", "=", "<", ">"} {
+		lhs, rhs, ok := strings.Cut(expr, op)
+		if !ok {
+			continue
+		}
+
+		x, err := r.operand(strings.TrimSpace(lhs))
+		if err != nil {
+			return false, r.errorf(c.line, "%v", err)
+		}
+		y, err := r.operand(strings.TrimSpace(rhs))
+		if err != nil {
+			return false, r.errorf(c.line, "%v", err)
+		}
+
+		switch op {
+		case "<>":
+			return x != y, nil
+		case "<=":
+			return x <= y, nil
+		case ">=":
+			return x >= y, nil
+		case "=":
+			return x == y, nil
+		case "<":
+			return x < y, nil
+		default:
+			return x > y, nil
+		}
+	}
+	return false, r.errorf(c.line, "invalid condition: %s", expr)
+}
+
+func (r *Runner) operand(s string) (int, error) {
+	if n, err := ParseValue(s); err == nil {
+		return n, nil
+	}
+	v, err := r.Target.Get(s)
+	return v.N, err
+}
+
+func (r *Runner) run(cmds []command) error {
+	for _, c := range cmds {
+		if err := r.exec(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) exec(c command) error {
+	args := c.args[1:]
+	need := func(n int) error {
+		if len(args) != n {
+			return r.errorf(c.line, "%s expects %d argument(s)", c.args[0], n)
+		}
+		return nil
+	}
+
+	switch c.args[0] {
+	case "load":
+		if err := need(1); err != nil {
+			return err
+		}
+		if err := r.Target.Load(r.Path(args[0])); err != nil {
+			return r.errorf(c.line, "%v", err)
+		}
+
+	case "output-file":
+		if err := need(1); err != nil {
+			return err
+		}
+		r.outName = args[0]
+
+	case "compare-to":
+		if err := need(1); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(r.Path(args[0]))
+		if err != nil {
+			return r.errorf(c.line, "%v", err)
+		}
+		r.cmpName = args[0]
+		r.cmp = strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+		// output-list より後に compare-to が来た場合, 既に出力した行も比べる
+		for i, s := range r.Output() {
+			if err := r.compare(c.line, i+1, s); err != nil {
+				return err
+			}
+		}
+
+	case "output-list":
+		r.columns = r.columns[:0]
+		for _, a := range args {
+			def := "%D1.6.1"
+			if df, ok := r.Target.(DefaultFormatter); ok {
+				def = df.DefaultFormat(strings.SplitN(a, "%", 2)[0])
+			}
+			col, err := parseColumn(a, def)
+			if err != nil {
+				return r.errorf(c.line, "%v", err)
+			}
+			r.columns = append(r.columns, col)
+		}
+		return r.header(c.line)
+
+	case "output":
+		if err := need(0); err != nil {
+			return err
+		}
+		return r.output(c.line)
+
+	case "echo":
+		if r.Echo != nil {
+			fmt.Fprintln(r.Echo, strings.Join(args, " "))
+		}
+
+	case "clear-echo":
+
+	case "set":
+		if err := need(2); err != nil {
+			return err
+		}
+		n, err := ParseValue(args[1])
+		if err != nil {
+			return r.errorf(c.line, "%v", err)
+		}
+		if err := r.Target.Set(args[0], n); err != nil {
+			return r.errorf(c.line, "%v", err)
+		}
+
+	case "repeat":
+		n := -1
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v < 0 {
+				return r.errorf(c.line, "invalid repeat count: %s", args[0])
+			}
+			n = v
+		}
+		for i := 0; n < 0 || i < n; i++ {
+			if i >= maxIterations {
+				return r.errorf(c.line, "repeat did not finish after %d iterations", maxIterations)
+			}
+			if err := r.run(c.body); err != nil {
+				return err
+			}
+		}
+
+	case "while":
+		for i := 0; ; i++ {
+			ok, err := r.cond(c)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if i >= maxIterations {
+				return r.errorf(c.line, "while did not finish after %d iterations", maxIterations)
+			}
+			if err := r.run(c.body); err != nil {
+				return err
+			}
+		}
+
+	default:
+		ok, err := r.Target.Exec(c.args[0], args)
+		if err != nil {
+			return r.errorf(c.line, "%v", err)
+		}
+		if !ok {
+			return r.errorf(c.line, "unknown command: %s", c.args[0])
+		}
+	}
+
+	return nil
+}
+
+// スクリプトを実行する. compare-to との不一致は *Error として返す
+func (r *Runner) Run(name string, src []byte) error {
+	cmds, err := parse(filepath.Base(name), string(src))
+	if err != nil {
+		return err
+	}
+
+	r.name = filepath.Base(name)
+	r.dir = filepath.Dir(name)
+	r.columns, r.cmp, r.outName = nil, nil, ""
+	r.out.Reset()
+	r.lines = 0
+
+	err = r.run(cmds)
+	if r.WriteOutput && r.outName != "" {
+		if werr := os.WriteFile(r.Path(r.outName), r.out.Bytes(), 0644); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+func (r *Runner) RunFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.Run(path, src)
+}
+
+// 出力した行 (output-file に書かれる内容)
+func (r *Runner) Output() []string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(r.out.Bytes()))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
@@ -0,0 +1,11 @@
+package tst
+
+import "testing"
+
+func TestRunMult(t *testing.T) {
+	r := NewRunner(NewCPUTarget())
+	r.WriteOutput = false
+	if err := r.RunFile("testdata/Mult.tst"); err != nil {
+		t.Fatal(err)
+	}
+}
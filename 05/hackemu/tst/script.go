@@ -0,0 +1,179 @@
+package tst
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Error struct {
+	Name string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Name, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type token struct {
+	text string
+	line int
+	// 文字列リテラルかどうか (echo "..." の引数)
+	quoted bool
+}
+
+func isPunct(c byte) bool {
+	return c == ',' || c == ';' || c == '!' || c == '{' || c == '}'
+}
+
+func tokenize(name, src string) ([]token, error) {
+	var tokens []token
+	line := 1
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, &Error{name, line, fmt.Errorf("unterminated comment")}
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+
+		case c == '"':
+			end := strings.IndexAny(src[i+1:], "\"\n")
+			if end == -1 || src[i+1+end] != '"' {
+				return nil, &Error{name, line, fmt.Errorf("unterminated string")}
+			}
+			tokens = append(tokens, token{src[i+1 : i+1+end], line, true})
+			i += end + 2
+
+		case isPunct(c):
+			tokens = append(tokens, token{string(c), line, false})
+			i++
+
+		default:
+			j := i
+			for j < len(src) && !isPunct(src[j]) && !strings.ContainsRune(" \t\r\n\"", rune(src[j])) {
+				if strings.HasPrefix(src[j:], "//") || strings.HasPrefix(src[j:], "/*") {
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, token{src[i:j], line, false})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+// 1 つのコマンド. repeat と while は body を持つ
+type command struct {
+	line int
+	args []string
+	// echo の引数のように引用符で囲まれていたか
+	quoted []bool
+	body   []command
+}
+
+type parser struct {
+	name   string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) errorf(line int, format string, a ...any) error {
+	return &Error{p.name, line, fmt.Errorf(format, a...)}
+}
+
+// 閉じ括弧 (またはファイルの終わり) までのコマンド列を読む
+func (p *parser) block(nested bool, open int) ([]command, error) {
+	var cmds []command
+	var cur *command
+
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+
+		if t.quoted {
+			if cur == nil {
+				return nil, p.errorf(t.line, "unexpected string")
+			}
+			cur.args = append(cur.args, t.text)
+			cur.quoted = append(cur.quoted, true)
+			continue
+		}
+
+		switch t.text {
+		case ",", ";", "!":
+			if cur != nil {
+				cmds = append(cmds, *cur)
+				cur = nil
+			}
+
+		case "{":
+			if cur == nil || (cur.args[0] != "repeat" && cur.args[0] != "while") {
+				return nil, p.errorf(t.line, "unexpected {")
+			}
+			body, err := p.block(true, t.line)
+			if err != nil {
+				return nil, err
+			}
+			cur.body = body
+			cmds = append(cmds, *cur)
+			cur = nil
+
+		case "}":
+			if !nested {
+				return nil, p.errorf(t.line, "unexpected }")
+			}
+			if cur != nil {
+				return nil, p.errorf(cur.line, "missing ; after %s", cur.args[0])
+			}
+			return cmds, nil
+
+		default:
+			if cur == nil {
+				cur = &command{line: t.line}
+			}
+			cur.args = append(cur.args, t.text)
+			cur.quoted = append(cur.quoted, false)
+		}
+	}
+
+	if nested {
+		return nil, p.errorf(open, "missing }")
+	}
+	if cur != nil {
+		return nil, p.errorf(cur.line, "missing ; after %s", cur.args[0])
+	}
+	return cmds, nil
+}
+
+func parse(name, src string) ([]command, error) {
+	tokens, err := tokenize(name, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{name: name, tokens: tokens}
+	return p.block(false, 0)
+}
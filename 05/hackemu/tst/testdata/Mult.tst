@@ -0,0 +1,38 @@
+// 04/Mult.asm を R0 * R1 の組み合わせで動かす
+
+load ../../../../04/Mult.asm,
+output-file Mult.out,
+compare-to Mult.cmp,
+output-list RAM[0]%D2.6.2 RAM[1]%D2.6.2 RAM[2]%D2.6.2;
+
+set RAM[0] 0,
+set RAM[1] 2,
+set RAM[2] -1;
+repeat 80 {
+  ticktock;
+}
+set RAM[0] 0,   // Mult.asm は引数をループカウンタに使う
+set RAM[1] 2,
+output;
+
+set PC 0,
+set RAM[0] 3,
+set RAM[1] 1,
+set RAM[2] -1;
+repeat 120 {
+  ticktock;
+}
+set RAM[0] 3,
+set RAM[1] 1,
+output;
+
+set PC 0,
+set RAM[0] 6,
+set RAM[1] 7,
+set RAM[2] -1;
+repeat 210 {
+  ticktock;
+}
+set RAM[0] 6,
+set RAM[1] 7,
+output;
@@ -0,0 +1,40 @@
+package hdl
+
+// Go で実装した部品の 1 つの実体. in, out はピンごとの値で, 順序は IN, OUT の宣言順
+type Device interface {
+	Eval(in, out []int)
+	// クロックの立ち上がりで入力を取り込む
+	Tick(in []int)
+	// クロックの立ち下がりで状態を更新する. 出力はこの後の Eval で変わる
+	Tock()
+}
+
+type Pin struct {
+	Name  string
+	Width int
+}
+
+type Builtin struct {
+	Name string
+	In   []Pin
+	Out  []Pin
+	// 出力に組み合わせ的に影響する入力. 順序付けとループの検出に使う
+	Comb []string
+	New  func() Device
+}
+
+// Nand と DFF はどの .hdl よりも優先する.
+// New は持たず, シミュレータが nandGate, dffGate として直接組み立てる
+var primitives = map[string]*Builtin{
+	"Nand": {
+		Name: "Nand",
+		In:   []Pin{{"a", 1}, {"b", 1}},
+		Out:  []Pin{{"out", 1}},
+		Comb: []string{"a", "b"},
+	},
+	"DFF": {
+		Name: "DFF",
+		In:   []Pin{{"in", 1}},
+		Out:  []Pin{{"out", 1}},
+	},
+}
@@ -0,0 +1,227 @@
+package hdl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type bitRef struct {
+	pin string
+	bit int
+}
+
+func (b bitRef) String() string {
+	return fmt.Sprintf("%s[%d]", b.pin, b.bit)
+}
+
+// チップ内のピンのビットの間の組み合わせ的なつながり. part は Parts の添字
+type edge struct {
+	to   bitRef
+	part int
+}
+
+// 部品を通って入力から出力に値が伝わるビットのつながり
+func (c *Chip) graph() map[bitRef][]edge {
+	g := map[bitRef][]edge{}
+	for i, part := range c.Parts {
+		ins := map[bitRef]bitRef{}
+		outs := map[bitRef][]bitRef{}
+		for _, w := range part.Wires {
+			if w.Outer == "true" || w.Outer == "false" {
+				continue
+			}
+			for k := range w.Width {
+				inner, outer := bitRef{w.Pin, w.PinLo + k}, bitRef{w.Outer, w.OuterLo + k}
+				if w.Output {
+					outs[inner] = append(outs[inner], outer)
+				} else {
+					ins[inner] = outer
+				}
+			}
+		}
+
+		for out, deps := range part.Chip.combDeps() {
+			for _, to := range outs[out] {
+				for _, in := range deps {
+					if from, ok := ins[in]; ok {
+						g[from] = append(g[from], edge{to, i})
+					}
+				}
+			}
+		}
+	}
+	return g
+}
+
+func (c *Chip) combDeps() map[bitRef][]bitRef {
+	if c.comb != nil {
+		return c.comb
+	}
+	c.comb = map[bitRef][]bitRef{}
+
+	if c.Builtin != nil {
+		var deps []bitRef
+		for _, name := range c.Builtin.Comb {
+			p, _ := findPin(c.In, name)
+			for i := range p.Width {
+				deps = append(deps, bitRef{name, i})
+			}
+		}
+		for _, p := range c.Out {
+			for i := range p.Width {
+				c.comb[bitRef{p.Name, i}] = deps
+			}
+		}
+		return c.comb
+	}
+
+	// 入力のビットから辿れる出力のビットを集める
+	g := c.graph()
+	for _, p := range c.In {
+		for i := range p.Width {
+			in := bitRef{p.Name, i}
+			seen := map[bitRef]bool{in: true}
+			stack := []bitRef{in}
+			for len(stack) > 0 {
+				b := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				for _, e := range g[b] {
+					if !seen[e.to] {
+						seen[e.to] = true
+						stack = append(stack, e.to)
+					}
+				}
+			}
+			for b := range seen {
+				if _, ok := findPin(c.Out, b.pin); ok {
+					c.comb[b] = append(c.comb[b], in)
+				}
+			}
+		}
+	}
+	return c.comb
+}
+
+// 連続するビットを "out[0..7], out[12]" の形にまとめる
+func bitRanges(name string, bits []int) string {
+	var list []string
+	for i := 0; i < len(bits); {
+		j := i
+		for j+1 < len(bits) && bits[j+1] == bits[j]+1 {
+			j++
+		}
+		list = append(list, Bus{name, bits[i], bits[j], true, Pos{}}.String())
+		i = j + 1
+	}
+	return strings.Join(list, ", ")
+}
+
+func (c *Chip) checkOutputs() ErrorList {
+	driven := map[bitRef]bool{}
+	for _, part := range c.Parts {
+		for _, w := range part.Wires {
+			for k := range w.Width {
+				if w.Output {
+					driven[bitRef{w.Outer, w.OuterLo + k}] = true
+				}
+			}
+		}
+	}
+
+	var errs ErrorList
+	for _, p := range c.Def.Out {
+		if c.incomplete[p.Name] {
+			continue
+		}
+		var bits []int
+		for i := range p.Width {
+			if !driven[bitRef{p.Name, i}] {
+				bits = append(bits, i)
+			}
+		}
+		switch {
+		case len(bits) == p.Width:
+			errs = append(errs, errorf(p.Pos, "output pin %s is not connected", p.Name))
+		case len(bits) > 0:
+			errs = append(errs, errorf(p.Pos, "output pin %s is not connected at %s", p.Name, bitRanges(p.Name, bits)))
+		}
+	}
+	return errs
+}
+
+func (c *Chip) checkLoops() ErrorList {
+	g := c.graph()
+	nodes := make([]bitRef, 0, len(g))
+	for b := range g {
+		nodes = append(nodes, b)
+	}
+	slices.SortFunc(nodes, func(x, y bitRef) int {
+		if n := strings.Compare(x.pin, y.pin); n != 0 {
+			return n
+		}
+		return x.bit - y.bit
+	})
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[bitRef]int{}
+	var path []bitRef
+	var errs ErrorList
+	// 同じピンを通るループはビットが違っても 1 度だけ報告する
+	reported := map[string]bool{}
+
+	var visit func(b bitRef)
+	visit = func(b bitRef) {
+		state[b] = visiting
+		path = append(path, b)
+		for _, e := range g[b] {
+			switch state[e.to] {
+			case unvisited:
+				visit(e.to)
+			case visiting:
+				i := slices.Index(path, e.to)
+				// 多ビットのピンはビットを変えて何周もするので, 各ピンを 1 度だけ並べる
+				var names []string
+				for _, p := range path[i:] {
+					if !slices.Contains(names, p.pin) {
+						names = append(names, p.pin)
+					}
+				}
+				key := strings.Join(slices.Sorted(slices.Values(names)), ",")
+				if !reported[key] {
+					reported[key] = true
+					names = append(names, e.to.pin)
+					part := c.Parts[e.part]
+					errs = append(errs, errorf(
+						part.Def.Pos, "combinational loop through %s: %s",
+						part.Chip.Name, strings.Join(names, " -> "),
+					))
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[b] = done
+	}
+
+	for _, b := range nodes {
+		if state[b] == unvisited {
+			visit(b)
+		}
+	}
+	return errs
+}
+
+// シミュレーションせずに分かる誤りを調べる. 部品の解決で見つかるものは LoadFile が返す.
+// 解決に失敗したチップでも, つながっている部分は調べる
+func (c *Chip) Check() ErrorList {
+	if c.Def == nil || c.Builtin != nil {
+		return nil
+	}
+	errs := append(c.checkOutputs(), c.checkLoops()...)
+	errs.sort()
+	return errs
+}
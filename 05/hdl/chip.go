@@ -0,0 +1,428 @@
+package hdl
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// 部品のピン Pin[PinLo..] とチップ側のピン Outer[OuterLo..] を Width ビットつなぐ.
+// Outer が "true", "false" なら定数
+type Wire struct {
+	Pin     string
+	PinLo   int
+	Outer   string
+	OuterLo int
+	Width   int
+	// 部品の出力ピンかどうか
+	Output bool
+}
+
+type Part struct {
+	Chip  *Chip
+	Def   *PartDef
+	Wires []Wire
+}
+
+// 部品を解決したチップ. Builtin が nil でなければ Go の実装を使う
+type Chip struct {
+	Name     string
+	File     string
+	In       []Pin
+	Out      []Pin
+	Internal []Pin
+	Parts    []Part
+	Builtin  *Builtin
+	Def      *ChipDef
+
+	// 出力のビットに組み合わせ的に影響する入力のビット (check.go)
+	comb map[bitRef][]bitRef
+	// 解決のエラーで接続が欠けているピン. Check で重ねて報告しない
+	incomplete map[string]bool
+}
+
+func findPin(pins []Pin, name string) (Pin, bool) {
+	for _, p := range pins {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Pin{}, false
+}
+
+func fromBuiltin(b *Builtin) *Chip {
+	return &Chip{Name: b.Name, In: b.In, Out: b.Out, Builtin: b}
+}
+
+var errNotFound = errors.New("chip not found")
+
+// チップを名前で探す. 見つけた .hdl は部品ごとに再帰的に解決する.
+// 探す順序は Nand と DFF, Builtins, Path の .hdl, Standard
+type Library struct {
+	// .hdl を探すディレクトリ
+	Path []string
+	// .hdl よりも優先する Go の実装
+	Builtins map[string]*Builtin
+
+	chips   map[string]*Chip
+	loading map[string]bool
+}
+
+func NewLibrary(path ...string) *Library {
+	return &Library{
+		Path:     slices.Clone(path),
+		Builtins: map[string]*Builtin{},
+		chips:    map[string]*Chip{},
+		loading:  map[string]bool{},
+	}
+}
+
+// BUILTIN で指定された Go の実装
+func (l *Library) builtin(name string) (*Builtin, bool) {
+	if b, ok := primitives[name]; ok {
+		return b, true
+	}
+	if b, ok := l.Builtins[name]; ok {
+		return b, true
+	}
+	b, ok := Standard[name]
+	return b, ok
+}
+
+// 標準の組み込み部品を .hdl より優先して使う. "all" なら全て
+func (l *Library) UseBuiltin(names ...string) error {
+	for _, name := range names {
+		if name == "all" {
+			maps.Copy(l.Builtins, Standard)
+			continue
+		}
+		b, ok := Standard[name]
+		if !ok {
+			return fmt.Errorf("unknown builtin chip: %s", name)
+		}
+		l.Builtins[name] = b
+	}
+	return nil
+}
+
+func (l *Library) Chip(name string) (*Chip, error) {
+	if c, ok := l.chips[name]; ok {
+		return c, nil
+	}
+	b, ok := primitives[name]
+	if !ok {
+		b, ok = l.Builtins[name]
+	}
+
+	if !ok {
+		for _, dir := range l.Path {
+			path := filepath.Join(dir, name+".hdl")
+			if _, err := os.Stat(path); err == nil {
+				return l.LoadFile(path)
+			}
+		}
+		if b, ok = Standard[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", errNotFound, name)
+		}
+	}
+
+	c := fromBuiltin(b)
+	l.chips[name] = c
+	return c, nil
+}
+
+// これまでに .hdl から解決できたチップを名前順に返す
+func (l *Library) Loaded() []*Chip {
+	var chips []*Chip
+	for _, name := range slices.Sorted(maps.Keys(l.chips)) {
+		if c := l.chips[name]; c.File != "" {
+			chips = append(chips, c)
+		}
+	}
+	return chips
+}
+
+// .hdl を読み込む. そのディレクトリは部品を探す場所の先頭に加える
+func (l *Library) LoadFile(path string) (*Chip, error) {
+	if dir := filepath.Dir(path); !slices.Contains(l.Path, dir) {
+		l.Path = slices.Insert(l.Path, 0, dir)
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	def, err := Parse(path, src)
+	if err != nil {
+		return nil, err
+	}
+	if name := strings.TrimSuffix(filepath.Base(path), ".hdl"); def.Name != name {
+		return nil, errorf(def.Pos, "chip %s must be defined in %s.hdl", def.Name, def.Name)
+	}
+
+	if c, ok := l.chips[def.Name]; ok {
+		return c, nil
+	}
+	if l.loading[def.Name] {
+		return nil, errorf(def.Pos, "chip %s uses itself", def.Name)
+	}
+	l.loading[def.Name] = true
+	defer delete(l.loading, def.Name)
+
+	c, err := l.Resolve(path, def)
+	if err != nil {
+		return c, err
+	}
+	l.chips[def.Name] = c
+	return c, nil
+}
+
+// チップ側のピンの種類
+type pinKind int
+
+const (
+	IN_PIN pinKind = iota
+	OUT_PIN
+	INTERNAL_PIN
+)
+
+type chipPin struct {
+	width int
+	kind  pinKind
+	// どのビットが部品の出力につながっているか
+	driven []bool
+}
+
+type resolver struct {
+	c    *Chip
+	pins map[string]*chipPin
+	errs ErrorList
+	// 解決できなかった部品や存在しないピンにつながる名前. エラーを重ねて報告しない
+	unknown map[string]bool
+	// エラーになった接続の外側の名前
+	failed map[string]bool
+	// 部品の .hdl のエラー. そのファイルの位置で, 部品ごとに 1 度だけ報告する
+	partErrs ErrorList
+	badParts map[string]bool
+}
+
+func (r *resolver) errorf(pos Pos, format string, a ...any) {
+	r.errs = append(r.errs, errorf(pos, format, a...))
+}
+
+// b の範囲を width のピンの中で調べ, 先頭とビット数を返す
+func (r *resolver) span(b Bus, width int) (int, int, bool) {
+	if !b.Sub {
+		return 0, width, true
+	}
+	if b.Hi >= width {
+		r.errorf(b.Pos, "%v is out of range: %s has %d bit(s)", b, b.Name, width)
+		return 0, 0, false
+	}
+	return b.Lo, b.Hi - b.Lo + 1, true
+}
+
+func (r *resolver) declare(pins []PinDef, kind pinKind) []Pin {
+	var list []Pin
+	for _, p := range pins {
+		if _, ok := r.pins[p.Name]; ok || p.Name == "true" || p.Name == "false" {
+			r.errorf(p.Pos, "pin %s is already declared", p.Name)
+			continue
+		}
+		r.pins[p.Name] = &chipPin{width: p.Width, kind: kind, driven: make([]bool, p.Width)}
+		list = append(list, Pin{p.Name, p.Width})
+	}
+	return list
+}
+
+// 部品の出力が最初につながる内部ピンの幅を決める
+func (r *resolver) internal(part Part) {
+	for _, conn := range part.Def.Conns {
+		out, ok := findPin(part.Chip.Out, conn.Inner.Name)
+		if _, isIn := findPin(part.Chip.In, conn.Inner.Name); !ok && !isIn {
+			r.unknown[conn.Outer.Name] = true
+		}
+		if !ok || conn.Outer.IsConst() {
+			continue
+		}
+		if _, ok := r.pins[conn.Outer.Name]; ok {
+			continue
+		}
+		width := out.Width
+		if conn.Inner.Sub && conn.Inner.Hi < out.Width {
+			width = conn.Inner.Hi - conn.Inner.Lo + 1
+		}
+		r.pins[conn.Outer.Name] = &chipPin{width: width, kind: INTERNAL_PIN, driven: make([]bool, width)}
+		r.c.Internal = append(r.c.Internal, Pin{conn.Outer.Name, width})
+	}
+}
+
+func (r *resolver) connect(part *Part) {
+	assigned := map[string][]bool{}
+	for _, conn := range part.Def.Conns {
+		n := len(r.errs)
+		r.wire(part, conn, assigned)
+		if len(r.errs) > n {
+			r.failed[conn.Outer.Name] = true
+		}
+	}
+}
+
+// 1 つの接続を調べて part.Wires に加える. assigned は部品の入力のうち接続済みのビット
+func (r *resolver) wire(part *Part, conn Conn, assigned map[string][]bool) {
+	inner, outer := conn.Inner, conn.Outer
+
+	pin, isIn := findPin(part.Chip.In, inner.Name)
+	if !isIn {
+		var ok bool
+		if pin, ok = findPin(part.Chip.Out, inner.Name); !ok {
+			r.errorf(inner.Pos, "%s has no pin named %s", part.Chip.Name, inner.Name)
+			return
+		}
+	}
+	lo, width, ok := r.span(inner, pin.Width)
+	if !ok {
+		return
+	}
+	w := Wire{Pin: pin.Name, PinLo: lo, Outer: outer.Name, Width: width, Output: !isIn}
+
+	if isIn {
+		bits := assigned[pin.Name]
+		if bits == nil {
+			bits = make([]bool, pin.Width)
+			assigned[pin.Name] = bits
+		}
+		if slices.Contains(bits[lo:lo+width], true) {
+			r.errorf(inner.Pos, "%v is connected more than once", inner)
+			return
+		}
+		for i := range width {
+			bits[lo+i] = true
+		}
+	}
+
+	if outer.IsConst() {
+		if !isIn {
+			r.errorf(outer.Pos, "output %v cannot drive the constant %s", inner, outer.Name)
+		} else if outer.Sub {
+			r.errorf(outer.Pos, "the constant %s cannot have a sub bus", outer.Name)
+		} else {
+			part.Wires = append(part.Wires, w)
+		}
+		return
+	}
+
+	cp, ok := r.pins[outer.Name]
+	if !ok && !r.unknown[outer.Name] {
+		r.errorf(outer.Pos, "pin %s is not driven by any part", outer.Name)
+	}
+	if !ok {
+		return
+	}
+	switch {
+	case isIn && cp.kind == OUT_PIN:
+		r.errorf(outer.Pos, "output pin %s cannot be used as an input of a part", outer.Name)
+		return
+	case !isIn && cp.kind == IN_PIN:
+		r.errorf(outer.Pos, "input pin %s cannot be driven by a part", outer.Name)
+		return
+	case cp.kind == INTERNAL_PIN && outer.Sub:
+		r.errorf(outer.Pos, "sub bus of the internal pin %s cannot be used", outer.Name)
+		return
+	}
+
+	olo, owidth, ok := r.span(outer, cp.width)
+	if !ok {
+		return
+	}
+	if owidth != width {
+		r.errorf(outer.Pos, "width mismatch: %v has %d bit(s), %v has %d bit(s)", inner, width, outer, owidth)
+		return
+	}
+	w.OuterLo = olo
+
+	if !isIn {
+		if slices.Contains(cp.driven[olo:olo+owidth], true) {
+			r.errorf(outer.Pos, "%v is driven more than once", outer)
+			return
+		}
+		for i := range owidth {
+			cp.driven[olo+i] = true
+		}
+	}
+	part.Wires = append(part.Wires, w)
+}
+
+// 読み込んだ定義の部品を解決し, 接続を調べる.
+// エラーがあっても解決できたところまでのチップを返す. Check には使えるがシミュレーションはできない
+func (l *Library) Resolve(file string, def *ChipDef) (*Chip, error) {
+	c := &Chip{Name: def.Name, File: file, Def: def}
+	r := &resolver{c: c, pins: map[string]*chipPin{}, unknown: map[string]bool{}, failed: map[string]bool{}, badParts: map[string]bool{}}
+
+	c.In = r.declare(def.In, IN_PIN)
+	c.Out = r.declare(def.Out, OUT_PIN)
+
+	if def.Builtin != "" {
+		b, ok := l.builtin(def.Builtin)
+		if !ok {
+			return nil, errorf(def.Pos, "unknown builtin chip %s", def.Builtin)
+		}
+		c.Builtin = b
+		if len(r.errs) > 0 {
+			return nil, r.errs
+		}
+		return c, nil
+	}
+
+	for i := range def.Parts {
+		pd := &def.Parts[i]
+		pc, err := l.Chip(pd.Name)
+		if errors.Is(err, errNotFound) {
+			r.errorf(pd.Pos, "unknown chip %s", pd.Name)
+			for _, conn := range pd.Conns {
+				r.unknown[conn.Outer.Name] = true
+			}
+			continue
+		}
+		if err != nil {
+			var list ErrorList
+			var e *Error
+			switch {
+			case r.badParts[pd.Name]:
+				// 同じ部品のエラーは報告済み
+			case errors.As(err, &list):
+				r.partErrs = append(r.partErrs, list...)
+			case errors.As(err, &e):
+				r.partErrs = append(r.partErrs, e)
+			default:
+				return nil, err
+			}
+			r.badParts[pd.Name] = true
+			for _, conn := range pd.Conns {
+				r.unknown[conn.Outer.Name] = true
+			}
+			continue
+		}
+		c.Parts = append(c.Parts, Part{Chip: pc, Def: pd})
+	}
+
+	for _, part := range c.Parts {
+		r.internal(part)
+	}
+	for i := range c.Parts {
+		r.connect(&c.Parts[i])
+	}
+
+	if len(r.errs) > 0 || len(r.partErrs) > 0 {
+		c.incomplete = map[string]bool{}
+		maps.Copy(c.incomplete, r.unknown)
+		maps.Copy(c.incomplete, r.failed)
+		r.errs.sort()
+		return c, append(r.partErrs, r.errs...)
+	}
+	return c, nil
+}
@@ -0,0 +1,13 @@
+module hdl
+
+go 1.24.0
+
+require (
+	assembler v0.0.0
+	hackemu v0.0.0
+)
+
+replace (
+	assembler => ../../06/assembler
+	hackemu => ../hackemu
+)
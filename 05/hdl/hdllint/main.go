@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"hackemu/tst"
+	"hdl"
+)
+
+func main() {
+	path := flag.String("path", "", "additional directories to search for parts, separated by "+string(os.PathListSeparator))
+	builtin := flag.String("builtin", "", "comma-separated builtin chips to use instead of .hdl files, or \"all\"")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Panic("usage: hdllint [flags] <file.hdl|dir|dir/...>...")
+	}
+
+	files, err := tst.Collect(flag.Args(), ".hdl")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// 部品は調べる .hdl のあるディレクトリ全てから探す
+	var dirs []string
+	if *path != "" {
+		dirs = filepath.SplitList(*path)
+	}
+	for _, f := range files {
+		if dir := filepath.Dir(f); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	// 部品のエラーは使う側のファイルでも見つかるので 1 度だけ出す
+	reported := map[string]bool{}
+	report := func(msg string) {
+		if !reported[msg] {
+			reported[msg] = true
+			fmt.Println(msg)
+		}
+	}
+
+	for _, f := range files {
+		lib := hdl.NewLibrary(dirs...)
+		if *builtin != "" {
+			if err := lib.UseBuiltin(strings.Split(*builtin, ",")...); err != nil {
+				log.Panic(err)
+			}
+		}
+
+		c, err := lib.LoadFile(f)
+		var list hdl.ErrorList
+		switch {
+		case errors.As(err, &list):
+			for _, e := range list {
+				report(e.Error())
+			}
+		case err != nil:
+			report(err.Error())
+		}
+
+		// 解決に失敗しても, チップ自身と解決できた部品は調べる.
+		// 部品のエラーは解決のエラーと同じくそのファイルの位置で報告する
+		chips := lib.Loaded()
+		if c != nil && !slices.Contains(chips, c) {
+			chips = append(chips, c)
+		}
+		for _, c := range chips {
+			for _, e := range c.Check() {
+				report(e.Error())
+			}
+		}
+	}
+
+	if len(reported) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"hackemu/tst"
+	"hdl"
+)
+
+// 引数から .tst を集める. "dir/..." は再帰的に探す.
+// 見つけた .hdl のディレクトリは部品を探す場所にする
+func collect(args []string) (scripts, dirs []string, err error) {
+	files, err := tst.Collect(args, ".tst", ".hdl")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, path := range files {
+		switch filepath.Ext(path) {
+		case ".tst":
+			scripts = append(scripts, path)
+		case ".hdl":
+			if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return scripts, dirs, nil
+}
+
+func main() {
+	path := flag.String("path", "", "additional directories to search for parts, separated by "+string(os.PathListSeparator))
+	builtin := flag.String("builtin", "", "comma-separated builtin chips to use instead of .hdl files, or \"all\"")
+	verbose := flag.Bool("v", false, "print echo messages")
+	noOutput := flag.Bool("n", false, "do not write output files")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Panic("usage: hdltest [flags] <file.tst|dir|dir/...>...")
+	}
+
+	scripts, dirs, err := collect(flag.Args())
+	if err != nil {
+		log.Panic(err)
+	}
+	if *path != "" {
+		dirs = append(filepath.SplitList(*path), dirs...)
+	}
+
+	var echo io.Writer
+	if *verbose {
+		echo = os.Stdout
+	}
+
+	failed := 0
+	for _, script := range scripts {
+		lib := hdl.NewLibrary(dirs...)
+		if *builtin != "" {
+			if err := lib.UseBuiltin(strings.Split(*builtin, ",")...); err != nil {
+				log.Panic(err)
+			}
+		}
+
+		t := &chipTarget{lib: lib}
+		r := tst.NewRunner(t)
+		t.runner = r
+		r.Echo = echo
+		r.WriteOutput = !*noOutput
+
+		if err := r.RunFile(script); err != nil {
+			fmt.Printf("FAIL  %s\n      %s\n", script, strings.ReplaceAll(err.Error(), "\n", "\n      "))
+			failed++
+			continue
+		}
+		fmt.Printf("PASS  %s\n", script)
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", len(scripts)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
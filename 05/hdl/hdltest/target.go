@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"hackemu/tst"
+	"hdl"
+)
+
+// ハードウェアシミュレータ用のスクリプトの対象
+type chipTarget struct {
+	lib *hdl.Library
+	// ROM32K load Max.hack のファイルの場所を決める
+	runner *tst.Runner
+	sim    *hdl.Sim
+	time   int
+	// tick の後, tock の前
+	half bool
+}
+
+// .hdl がなければ同じ名前の組み込み部品を使う
+func (t *chipTarget) Load(path string) error {
+	c, err := t.lib.LoadFile(path)
+	if name := strings.TrimSuffix(filepath.Base(path), ".hdl"); errors.Is(err, fs.ErrNotExist) && hdl.Standard[name] != nil {
+		c, err = t.lib.Chip(name)
+	}
+	if err != nil {
+		return err
+	}
+	sim, err := hdl.New(c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	t.sim, t.time, t.half = sim, 0, false
+	return nil
+}
+
+func (t *chipTarget) loaded() error {
+	if t.sim == nil {
+		return fmt.Errorf("no chip is loaded")
+	}
+	return nil
+}
+
+func (t *chipTarget) Get(name string) (tst.Value, error) {
+	if name == "time" {
+		s := strconv.Itoa(t.time)
+		if t.half {
+			s += "+"
+		}
+		return tst.Value{N: t.time, Text: s}, nil
+	}
+	if err := t.loaded(); err != nil {
+		return tst.Value{}, err
+	}
+
+	n, err := t.sim.Get(name)
+	if err != nil {
+		return tst.Value{}, err
+	}
+	// 16 ビットのピンは符号付きで表示する
+	if w, _ := t.sim.Width(name); w == 16 && n >= 1<<15 {
+		n -= 1 << 16
+	}
+	return tst.Value{N: n}, nil
+}
+
+func (t *chipTarget) Set(name string, value int) error {
+	if err := t.loaded(); err != nil {
+		return err
+	}
+	return t.sim.Set(name, value)
+}
+
+func (t *chipTarget) Exec(cmd string, args []string) (bool, error) {
+	switch cmd {
+	case "eval", "tick", "tock":
+	default:
+		return t.load(cmd, args)
+	}
+	if len(args) > 0 {
+		return true, fmt.Errorf("%s takes no arguments", cmd)
+	}
+	if err := t.loaded(); err != nil {
+		return true, err
+	}
+
+	switch cmd {
+	case "eval":
+		t.sim.Eval()
+	case "tick":
+		t.sim.Tick()
+		t.half = true
+	case "tock":
+		t.sim.Tock()
+		t.time++
+		t.half = false
+	}
+	return true, nil
+}
+
+// "ROM32K load Max.hack" の形で組み込み部品に読み込む
+func (t *chipTarget) load(name string, args []string) (bool, error) {
+	if t.sim == nil || len(args) == 0 || args[0] != "load" {
+		return false, nil
+	}
+	dev, ok := t.sim.Device(name)
+	if !ok {
+		return false, nil
+	}
+	l, ok := dev.(hdl.Loader)
+	if !ok {
+		return true, fmt.Errorf("%s cannot load a file", name)
+	}
+	if len(args) != 2 {
+		return true, fmt.Errorf("%s load expects a file name", name)
+	}
+	return true, l.Load(t.runner.Path(args[1]))
+}
+
+// 書式を省略した列はピンの幅の 2 進数
+func (t *chipTarget) DefaultFormat(name string) string {
+	if name == "time" {
+		return "%S1.4.1"
+	}
+	w := 1
+	if t.sim != nil {
+		if n, ok := t.sim.Width(name); ok {
+			w = n
+		}
+	}
+	return fmt.Sprintf("%%B1.%d.1", w)
+}
@@ -0,0 +1,294 @@
+package hdl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 内部に値を持つ組み込み部品. スクリプトからは RAM16K[3] や DRegister[] で参照する
+type Memory interface {
+	Len() int
+	Peek(i int) int
+	Poke(i, value int)
+}
+
+// ファイルから内容を読み込める組み込み部品 (ROM32K load Max.hack)
+type Loader interface {
+	Load(path string) error
+}
+
+const mask16 = 0xffff
+
+type combinational func(in, out []int)
+
+func (f combinational) Eval(in, out []int) { f(in, out) }
+func (f combinational) Tick(in []int)      {}
+func (f combinational) Tock()              {}
+
+func pins(spec string) []Pin {
+	var list []Pin
+	for _, s := range strings.Fields(spec) {
+		name, width, ok := strings.Cut(s, "[")
+		w := 1
+		if ok {
+			w, _ = strconv.Atoi(strings.TrimSuffix(width, "]"))
+		}
+		list = append(list, Pin{name, w})
+	}
+	return list
+}
+
+// 全ての入力が出力に組み合わせ的に影響する部品
+func gate(name, in, out string, f func(in, out []int)) *Builtin {
+	b := &Builtin{Name: name, In: pins(in), Out: pins(out)}
+	for _, p := range b.In {
+		b.Comb = append(b.Comb, p.Name)
+	}
+	b.New = func() Device { return combinational(f) }
+	return b
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 入力: in, load
+type register struct {
+	value, next int
+	mask        int
+}
+
+func (r *register) Eval(in, out []int) { out[0] = r.value }
+func (r *register) Tock()              { r.value = r.next }
+
+func (r *register) Tick(in []int) {
+	r.next = r.value
+	if in[1] == 1 {
+		r.next = in[0]
+	}
+}
+
+func (r *register) Len() int          { return 1 }
+func (r *register) Peek(i int) int    { return r.value }
+func (r *register) Poke(i, value int) { r.value = value & r.mask }
+
+func registerChip(name string, width int) *Builtin {
+	return &Builtin{
+		Name: name,
+		In:   []Pin{{"in", width}, {"load", 1}},
+		Out:  []Pin{{"out", width}},
+		New:  func() Device { return &register{mask: 1<<width - 1} },
+	}
+}
+
+// 入力: in, reset, load, inc
+type counter struct {
+	register
+}
+
+func (c *counter) Tick(in []int) {
+	switch {
+	case in[1] == 1:
+		c.next = 0
+	case in[2] == 1:
+		c.next = in[0]
+	case in[3] == 1:
+		c.next = (c.value + 1) & mask16
+	default:
+		c.next = c.value
+	}
+}
+
+// 入力: in, load, address. 書き込みは tock で反映する
+type ram struct {
+	mem   []int
+	write bool
+	addr  int
+	next  int
+}
+
+func (r *ram) Eval(in, out []int) { out[0] = r.mem[in[2]] }
+
+func (r *ram) Tick(in []int) {
+	r.write, r.next, r.addr = in[1] == 1, in[0], in[2]
+}
+
+func (r *ram) Tock() {
+	if r.write {
+		r.mem[r.addr] = r.next
+	}
+	r.write = false
+}
+
+func (r *ram) Len() int          { return len(r.mem) }
+func (r *ram) Peek(i int) int    { return r.mem[i] }
+func (r *ram) Poke(i, value int) { r.mem[i] = value & mask16 }
+
+func ramChip(name string, bits int) *Builtin {
+	return &Builtin{
+		Name: name,
+		In:   []Pin{{"in", 16}, {"load", 1}, {"address", bits}},
+		Out:  []Pin{{"out", 16}},
+		Comb: []string{"address"},
+		New:  func() Device { return &ram{mem: make([]int, 1<<bits)} },
+	}
+}
+
+// 入力: address
+type rom struct {
+	mem []int
+}
+
+func (r *rom) Eval(in, out []int) { out[0] = r.mem[in[0]] }
+func (r *rom) Tick(in []int)      {}
+func (r *rom) Tock()              {}
+
+func (r *rom) Len() int          { return len(r.mem) }
+func (r *rom) Peek(i int) int    { return r.mem[i] }
+func (r *rom) Poke(i, value int) { r.mem[i] = value & mask16 }
+
+// 1 行に 1 語の 2 進数で書かれた .hack を読み込む
+func (r *rom) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	clear(r.mem)
+	sc := bufio.NewScanner(f)
+	for i := 0; sc.Scan(); {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if i >= len(r.mem) {
+			return fmt.Errorf("%s: program too large", path)
+		}
+		w, err := strconv.ParseUint(line, 2, 16)
+		if err != nil || len(line) != 16 {
+			return fmt.Errorf("%s: invalid instruction: %s", path, line)
+		}
+		r.mem[i] = int(w)
+		i++
+	}
+	return sc.Err()
+}
+
+// KBD の値. スクリプトから Keyboard[] で設定する
+type keyboard struct {
+	key int
+}
+
+func (k *keyboard) Eval(in, out []int) { out[0] = k.key }
+func (k *keyboard) Tick(in []int)      {}
+func (k *keyboard) Tock()              {}
+
+func (k *keyboard) Len() int          { return 1 }
+func (k *keyboard) Peek(i int) int    { return k.key }
+func (k *keyboard) Poke(i, value int) { k.key = value & mask16 }
+
+func alu(in, out []int) {
+	x, y := in[0], in[1]
+	if in[2] == 1 {
+		x = 0
+	}
+	if in[3] == 1 {
+		x = ^x & mask16
+	}
+	if in[4] == 1 {
+		y = 0
+	}
+	if in[5] == 1 {
+		y = ^y & mask16
+	}
+	o := x & y
+	if in[6] == 1 {
+		o = (x + y) & mask16
+	}
+	if in[7] == 1 {
+		o = ^o & mask16
+	}
+	out[0], out[1], out[2] = o, bit(o == 0), o>>15
+}
+
+func mux(sel int, in []int) int {
+	return in[sel]
+}
+
+// 0 番目の出力から順に sel で選んだものに in を出す
+func dmux(in, sel int, out []int) {
+	clear(out)
+	out[sel] = in
+}
+
+// .hdl が見つからないときに使う標準の組み込み部品. Nand と DFF は primitives にある
+var Standard = map[string]*Builtin{}
+
+func init() {
+	for _, b := range []*Builtin{
+		gate("Not", "in", "out", func(in, out []int) { out[0] = 1 &^ in[0] }),
+		gate("And", "a b", "out", func(in, out []int) { out[0] = in[0] & in[1] }),
+		gate("Or", "a b", "out", func(in, out []int) { out[0] = in[0] | in[1] }),
+		gate("Xor", "a b", "out", func(in, out []int) { out[0] = in[0] ^ in[1] }),
+		gate("Mux", "a b sel", "out", func(in, out []int) { out[0] = mux(in[2], in[:2]) }),
+		gate("DMux", "in sel", "a b", func(in, out []int) { dmux(in[0], in[1], out) }),
+		gate("Not16", "in[16]", "out[16]", func(in, out []int) { out[0] = ^in[0] & mask16 }),
+		gate("And16", "a[16] b[16]", "out[16]", func(in, out []int) { out[0] = in[0] & in[1] }),
+		gate("Or16", "a[16] b[16]", "out[16]", func(in, out []int) { out[0] = in[0] | in[1] }),
+		gate("Mux16", "a[16] b[16] sel", "out[16]", func(in, out []int) { out[0] = mux(in[2], in[:2]) }),
+		gate("Mux4Way16", "a[16] b[16] c[16] d[16] sel[2]", "out[16]", func(in, out []int) { out[0] = mux(in[4], in[:4]) }),
+		gate("Mux8Way16", "a[16] b[16] c[16] d[16] e[16] f[16] g[16] h[16] sel[3]", "out[16]", func(in, out []int) { out[0] = mux(in[8], in[:8]) }),
+		gate("DMux4Way", "in sel[2]", "a b c d", func(in, out []int) { dmux(in[0], in[1], out) }),
+		gate("DMux8Way", "in sel[3]", "a b c d e f g h", func(in, out []int) { dmux(in[0], in[1], out) }),
+		gate("Or8Way", "in[8]", "out", func(in, out []int) { out[0] = bit(in[0] != 0) }),
+		gate("HalfAdder", "a b", "sum carry", func(in, out []int) {
+			out[0], out[1] = in[0]^in[1], in[0]&in[1]
+		}),
+		gate("FullAdder", "a b c", "sum carry", func(in, out []int) {
+			s := in[0] + in[1] + in[2]
+			out[0], out[1] = s&1, s>>1
+		}),
+		gate("Add16", "a[16] b[16]", "out[16]", func(in, out []int) { out[0] = (in[0] + in[1]) & mask16 }),
+		gate("Inc16", "in[16]", "out[16]", func(in, out []int) { out[0] = (in[0] + 1) & mask16 }),
+		gate("ALU", "x[16] y[16] zx nx zy ny f no", "out[16] zr ng", alu),
+
+		registerChip("Bit", 1),
+		registerChip("Register", 16),
+		registerChip("ARegister", 16),
+		registerChip("DRegister", 16),
+		{
+			Name: "PC",
+			In:   pins("in[16] reset load inc"),
+			Out:  pins("out[16]"),
+			New:  func() Device { return &counter{register{mask: mask16}} },
+		},
+
+		ramChip("RAM8", 3),
+		ramChip("RAM64", 6),
+		ramChip("RAM512", 9),
+		ramChip("RAM4K", 12),
+		ramChip("RAM16K", 14),
+		ramChip("Screen", 13),
+		{
+			Name: "ROM32K",
+			In:   pins("address[15]"),
+			Out:  pins("out[16]"),
+			Comb: []string{"address"},
+			New:  func() Device { return &rom{mem: make([]int, 1<<15)} },
+		},
+		{
+			Name: "Keyboard",
+			Out:  pins("out[16]"),
+			New:  func() Device { return &keyboard{} },
+		},
+	} {
+		Standard[b.Name] = b
+	}
+}
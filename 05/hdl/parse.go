@@ -0,0 +1,412 @@
+package hdl
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+}
+
+// 1 つのファイルやチップで見つかったエラーをまとめて返す
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// 行と列の順に並べる
+func (l ErrorList) sort() {
+	slices.SortStableFunc(l, func(x, y *Error) int {
+		if x.Pos.Line != y.Pos.Line {
+			return x.Pos.Line - y.Pos.Line
+		}
+		return x.Pos.Col - y.Pos.Col
+	})
+}
+
+func errorf(pos Pos, format string, a ...any) *Error {
+	return &Error{pos, fmt.Sprintf(format, a...)}
+}
+
+// IN, OUT の宣言. "in[16]" は幅 16
+type PinDef struct {
+	Name  string
+	Width int
+	Pos   Pos
+}
+
+// 接続の片側. "out[0..14]" なら Lo=0, Hi=14, Sub=true
+type Bus struct {
+	Name string
+	Lo   int
+	Hi   int
+	Sub  bool
+	Pos  Pos
+}
+
+func (b Bus) String() string {
+	switch {
+	case !b.Sub:
+		return b.Name
+	case b.Lo == b.Hi:
+		return fmt.Sprintf("%s[%d]", b.Name, b.Lo)
+	}
+	return fmt.Sprintf("%s[%d..%d]", b.Name, b.Lo, b.Hi)
+}
+
+func (b Bus) IsConst() bool {
+	return b.Name == "true" || b.Name == "false"
+}
+
+// 部品側のピン = チップ側のピン
+type Conn struct {
+	Inner Bus
+	Outer Bus
+}
+
+type PartDef struct {
+	Name  string
+	Conns []Conn
+	Pos   Pos
+}
+
+type ChipDef struct {
+	Name  string
+	In    []PinDef
+	Out   []PinDef
+	Parts []PartDef
+	// BUILTIN で Go の実装を指定したときの名前
+	Builtin string
+	Clocked []string
+	Pos     Pos
+}
+
+type token struct {
+	text string
+	pos  Pos
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func tokenize(file, src string) ([]token, error) {
+	var tokens []token
+	line, lineStart := 1, 0
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		pos := Pos{file, line, i - lineStart + 1}
+		switch {
+		case c == '\n':
+			line++
+			i++
+			lineStart = i
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, errorf(pos, "unterminated comment")
+			}
+			end += i + 4
+			for ; i < end; i++ {
+				if src[i] == '\n' {
+					line++
+					lineStart = i + 1
+				}
+			}
+
+		case strings.HasPrefix(src[i:], ".."):
+			tokens = append(tokens, token{"..", pos})
+			i += 2
+
+		case strings.ContainsRune("{}()[],;=:", rune(c)):
+			tokens = append(tokens, token{string(c), pos})
+			i++
+
+		case isIdent(c):
+			j := i
+			for j < len(src) && isIdent(src[j]) {
+				j++
+			}
+			tokens = append(tokens, token{src[i:j], pos})
+			i = j
+
+		default:
+			return nil, errorf(pos, "unexpected character %q", c)
+		}
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	// ファイル末尾の位置
+	end Pos
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return token{"", p.end}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(s string) bool {
+	if p.peek().text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) (token, error) {
+	t := p.next()
+	if t.text != s {
+		return t, unexpected(t, strconv.Quote(s))
+	}
+	return t, nil
+}
+
+func unexpected(t token, want string) error {
+	if t.text == "" {
+		return errorf(t.pos, "expected %s, found end of file", want)
+	}
+	return errorf(t.pos, "expected %s, found %q", want, t.text)
+}
+
+func (p *parser) ident() (token, error) {
+	t := p.next()
+	if t.text == "" || !isIdent(t.text[0]) || ('0' <= t.text[0] && t.text[0] <= '9') {
+		return t, unexpected(t, "name")
+	}
+	return t, nil
+}
+
+func (p *parser) number() (int, error) {
+	t := p.next()
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, unexpected(t, "number")
+	}
+	return n, nil
+}
+
+// "a, b[16], c;"
+func (p *parser) pins() ([]PinDef, error) {
+	var pins []PinDef
+	for {
+		t, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		pin := PinDef{t.text, 1, t.pos}
+		if p.accept("[") {
+			if pin.Width, err = p.number(); err != nil {
+				return nil, err
+			}
+			if pin.Width < 1 || pin.Width > 16 {
+				return nil, errorf(t.pos, "invalid width of %s: %d", t.text, pin.Width)
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		pins = append(pins, pin)
+
+		if p.accept(";") {
+			return pins, nil
+		}
+		if _, err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) bus() (Bus, error) {
+	t, err := p.ident()
+	if err != nil {
+		return Bus{}, err
+	}
+	b := Bus{Name: t.text, Pos: t.pos}
+	if !p.accept("[") {
+		return b, nil
+	}
+
+	b.Sub = true
+	if b.Lo, err = p.number(); err != nil {
+		return b, err
+	}
+	b.Hi = b.Lo
+	if p.accept("..") {
+		if b.Hi, err = p.number(); err != nil {
+			return b, err
+		}
+	}
+	if b.Lo > b.Hi {
+		return b, errorf(t.pos, "invalid range: %v", b)
+	}
+	_, err = p.expect("]")
+	return b, err
+}
+
+func (p *parser) part() (PartDef, error) {
+	t, err := p.ident()
+	if err != nil {
+		return PartDef{}, err
+	}
+	part := PartDef{Name: t.text, Pos: t.pos}
+	if _, err := p.expect("("); err != nil {
+		return part, err
+	}
+
+	// 最後の接続の後の "," は許す
+	for !p.accept(")") {
+		if len(part.Conns) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return part, err
+			}
+			if p.accept(")") {
+				break
+			}
+		}
+		inner, err := p.bus()
+		if err != nil {
+			return part, err
+		}
+		if _, err := p.expect("="); err != nil {
+			return part, err
+		}
+		outer, err := p.bus()
+		if err != nil {
+			return part, err
+		}
+		part.Conns = append(part.Conns, Conn{inner, outer})
+	}
+
+	_, err = p.expect(";")
+	return part, err
+}
+
+func (p *parser) chip() (*ChipDef, error) {
+	t, err := p.expect("CHIP")
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	def := &ChipDef{Name: name.text, Pos: t.pos}
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	if p.accept("IN") {
+		if def.In, err = p.pins(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("OUT") {
+		if def.Out, err = p.pins(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch t := p.next(); t.text {
+	case "PARTS":
+		if _, err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		for p.peek().text != "}" && p.peek().text != "" {
+			part, err := p.part()
+			if err != nil {
+				return nil, err
+			}
+			def.Parts = append(def.Parts, part)
+		}
+
+	case "BUILTIN":
+		b, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		def.Builtin = b.text
+		if _, err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		if p.accept("CLOCKED") {
+			pins, err := p.pins()
+			if err != nil {
+				return nil, err
+			}
+			for _, pin := range pins {
+				def.Clocked = append(def.Clocked, pin.Name)
+			}
+		}
+
+	default:
+		return nil, unexpected(t, "PARTS or BUILTIN")
+	}
+
+	if _, err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.text != "" {
+		return nil, unexpected(t, "end of file")
+	}
+	return def, nil
+}
+
+// file はエラーの位置に使う
+func Parse(file string, src []byte) (*ChipDef, error) {
+	tokens, err := tokenize(file, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(src), "\n")
+	p := &parser{tokens: tokens, end: Pos{file, len(lines), len(lines[len(lines)-1]) + 1}}
+	return p.chip()
+}
@@ -0,0 +1,479 @@
+package hdl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 0 と 1 番の net は定数 false, true
+const (
+	FALSE_NET = 0
+	TRUE_NET  = 1
+)
+
+// 展開した回路の 1 つの部品
+type component interface {
+	eval(v []bool)
+	// 出力に組み合わせ的に影響する入力の net
+	deps() []int
+	outputs() []int
+	remap(root func(int) int)
+}
+
+type clocked interface {
+	tick(v []bool)
+	tock()
+}
+
+type nandGate struct {
+	a, b, out int
+}
+
+func (g *nandGate) eval(v []bool)  { v[g.out] = !(v[g.a] && v[g.b]) }
+func (g *nandGate) deps() []int    { return []int{g.a, g.b} }
+func (g *nandGate) outputs() []int { return []int{g.out} }
+
+func (g *nandGate) remap(root func(int) int) {
+	g.a, g.b, g.out = root(g.a), root(g.b), root(g.out)
+}
+
+type dffGate struct {
+	in, out     int
+	value, next bool
+}
+
+func (g *dffGate) eval(v []bool)  { v[g.out] = g.value }
+func (g *dffGate) deps() []int    { return nil }
+func (g *dffGate) outputs() []int { return []int{g.out} }
+func (g *dffGate) tick(v []bool)  { g.next = v[g.in] }
+func (g *dffGate) tock()          { g.value = g.next }
+
+func (g *dffGate) remap(root func(int) int) {
+	g.in, g.out = root(g.in), root(g.out)
+}
+
+// Go で実装した部品. ピンごとに net の値をまとめて Device に渡す
+type device struct {
+	dev     Device
+	in, out [][]int
+	comb    []int
+	inv     []int
+	outv    []int
+}
+
+func (d *device) gather(v []bool) {
+	for i, nets := range d.in {
+		n := 0
+		for bit, net := range nets {
+			if v[net] {
+				n |= 1 << bit
+			}
+		}
+		d.inv[i] = n
+	}
+}
+
+func (d *device) eval(v []bool) {
+	d.gather(v)
+	d.dev.Eval(d.inv, d.outv)
+	for i, nets := range d.out {
+		for bit, net := range nets {
+			v[net] = d.outv[i]>>bit&1 == 1
+		}
+	}
+}
+
+func (d *device) tick(v []bool) {
+	d.gather(v)
+	d.dev.Tick(d.inv)
+}
+
+func (d *device) tock() {
+	d.dev.Tock()
+}
+
+func (d *device) deps() []int {
+	return d.comb
+}
+
+func (d *device) outputs() []int {
+	var nets []int
+	for _, pin := range d.out {
+		nets = append(nets, pin...)
+	}
+	return nets
+}
+
+func (d *device) remap(root func(int) int) {
+	for _, pins := range [][][]int{d.in, d.out, {d.comb}} {
+		for _, nets := range pins {
+			for i, n := range nets {
+				nets[i] = root(n)
+			}
+		}
+	}
+}
+
+// チップを Nand, DFF と Go の部品まで展開する. ピンの接続は net の併合で表す
+type builder struct {
+	parent  []int
+	comps   []component
+	paths   []string
+	devices map[string]Device
+}
+
+func (b *builder) alloc(width int) []int {
+	nets := make([]int, width)
+	for i := range nets {
+		nets[i] = len(b.parent)
+		b.parent = append(b.parent, len(b.parent))
+	}
+	return nets
+}
+
+func (b *builder) find(x int) int {
+	for b.parent[x] != x {
+		b.parent[x] = b.parent[b.parent[x]]
+		x = b.parent[x]
+	}
+	return x
+}
+
+// 小さい番号を根にするので定数の net は常に根になる
+func (b *builder) union(x, y int) {
+	x, y = b.find(x), b.find(y)
+	if x > y {
+		x, y = y, x
+	}
+	b.parent[y] = x
+}
+
+func (b *builder) add(comp component, path string) {
+	b.comps = append(b.comps, comp)
+	b.paths = append(b.paths, path)
+}
+
+func (b *builder) builtin(c *Chip, nets map[string][]int, path string) {
+	bi := c.Builtin
+	switch bi {
+	case primitives["Nand"]:
+		b.add(&nandGate{nets["a"][0], nets["b"][0], nets["out"][0]}, path)
+		return
+	case primitives["DFF"]:
+		b.add(&dffGate{in: nets["in"][0], out: nets["out"][0]}, path)
+		return
+	}
+
+	d := &device{dev: bi.New(), inv: make([]int, len(bi.In)), outv: make([]int, len(bi.Out))}
+	for _, p := range bi.In {
+		d.in = append(d.in, nets[p.Name])
+	}
+	for _, p := range bi.Out {
+		d.out = append(d.out, nets[p.Name])
+	}
+	for _, name := range bi.Comb {
+		d.comb = append(d.comb, nets[name]...)
+	}
+	b.add(d, path)
+	if _, ok := b.devices[bi.Name]; !ok {
+		b.devices[bi.Name] = d.dev
+	}
+}
+
+// チップの実体を 1 つ作り, ピンの名前から net への対応を返す
+func (b *builder) instance(c *Chip, path string) map[string][]int {
+	nets := map[string][]int{}
+	for _, pins := range [][]Pin{c.In, c.Out, c.Internal} {
+		for _, p := range pins {
+			nets[p.Name] = b.alloc(p.Width)
+		}
+	}
+
+	if c.Builtin != nil {
+		b.builtin(c, nets, path)
+		return nets
+	}
+
+	for _, part := range c.Parts {
+		sub := b.instance(part.Chip, path+"/"+part.Chip.Name)
+
+		connected := map[string][]bool{}
+		for _, w := range part.Wires {
+			for i := range w.Width {
+				inner := sub[w.Pin][w.PinLo+i]
+				switch w.Outer {
+				case "true":
+					b.union(inner, TRUE_NET)
+				case "false":
+					b.union(inner, FALSE_NET)
+				default:
+					b.union(inner, nets[w.Outer][w.OuterLo+i])
+				}
+			}
+			if !w.Output {
+				if connected[w.Pin] == nil {
+					connected[w.Pin] = make([]bool, len(sub[w.Pin]))
+				}
+				for i := range w.Width {
+					connected[w.Pin][w.PinLo+i] = true
+				}
+			}
+		}
+
+		// つながっていない入力は false
+		for _, p := range part.Chip.In {
+			for i, net := range sub[p.Name] {
+				if connected[p.Name] == nil || !connected[p.Name][i] {
+					b.union(net, FALSE_NET)
+				}
+			}
+		}
+	}
+
+	return nets
+}
+
+type Sim struct {
+	Chip *Chip
+
+	v       []bool
+	comps   []component
+	clocked []clocked
+	pins    map[string][]int
+	inputs  map[string]bool
+	devices map[string]Device
+}
+
+// 組み合わせ回路の部品を入力から出力の順に並べる
+func (s *Sim) sort(paths []string) error {
+	driver := make([]int, len(s.v))
+	for i := range driver {
+		driver[i] = -1
+	}
+	for i, c := range s.comps {
+		for _, net := range c.outputs() {
+			if driver[net] >= 0 || net == FALSE_NET || net == TRUE_NET {
+				return fmt.Errorf("%s: net driven more than once", paths[i])
+			}
+			driver[net] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(s.comps))
+	order := make([]component, 0, len(s.comps))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("combinational loop through %s", paths[i])
+		case done:
+			return nil
+		}
+		state[i] = visiting
+		for _, net := range s.comps[i].deps() {
+			if j := driver[net]; j >= 0 {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		order = append(order, s.comps[i])
+		return nil
+	}
+
+	for i := range s.comps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	s.comps = order
+	return nil
+}
+
+func New(c *Chip) (*Sim, error) {
+	b := &builder{devices: map[string]Device{}}
+	b.alloc(2)
+	top := b.instance(c, c.Name)
+
+	// 根の net に 0 から番号を振り直す
+	ids := make([]int, len(b.parent))
+	n := 0
+	for i := range b.parent {
+		if b.find(i) == i {
+			ids[i] = n
+			n++
+		}
+	}
+	root := func(x int) int {
+		return ids[b.find(x)]
+	}
+
+	s := &Sim{
+		Chip:    c,
+		v:       make([]bool, n),
+		comps:   b.comps,
+		pins:    map[string][]int{},
+		inputs:  map[string]bool{},
+		devices: b.devices,
+	}
+	for name, nets := range top {
+		for i := range nets {
+			nets[i] = root(nets[i])
+		}
+		s.pins[name] = nets
+	}
+	for _, p := range c.In {
+		s.inputs[p.Name] = true
+	}
+	for _, comp := range s.comps {
+		comp.remap(root)
+		if cl, ok := comp.(clocked); ok {
+			s.clocked = append(s.clocked, cl)
+		}
+	}
+
+	if err := s.sort(b.paths); err != nil {
+		return nil, err
+	}
+	s.v[TRUE_NET] = true
+	s.Eval()
+	return s, nil
+}
+
+// "out", "out[3]", "out[0..7]" の net
+func (s *Sim) bus(name string) ([]int, error) {
+	tokens, err := tokenize("", name)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	b, err := p.bus()
+	if err != nil || p.pos != len(tokens) {
+		return nil, fmt.Errorf("invalid pin name: %s", name)
+	}
+
+	nets, ok := s.pins[b.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown pin: %s", b.Name)
+	}
+	if !b.Sub {
+		return nets, nil
+	}
+	if b.Hi >= len(nets) {
+		return nil, fmt.Errorf("%s is out of range: %s has %d bit(s)", name, b.Name, len(nets))
+	}
+	return nets[b.Lo : b.Hi+1], nil
+}
+
+// 組み込み部品の実体を名前で探す. 同じ部品が複数あれば最初のもの
+func (s *Sim) Device(name string) (Device, bool) {
+	d, ok := s.devices[name]
+	return d, ok
+}
+
+// "RAM16K[3]" や "DRegister[]" で組み込み部品の値を参照する. 名前がピンなら ok は false
+func (s *Sim) memory(name string) (m Memory, i int, ok bool, err error) {
+	base, rest, found := strings.Cut(name, "[")
+	if !found || s.pins[base] != nil || s.devices[base] == nil {
+		return nil, 0, false, nil
+	}
+
+	m, isMem := s.devices[base].(Memory)
+	if !isMem {
+		return nil, 0, true, fmt.Errorf("%s has no memory", base)
+	}
+	if index := strings.TrimSuffix(rest, "]"); index != "" {
+		i, err = strconv.Atoi(index)
+		if err != nil || i < 0 || i >= m.Len() {
+			return nil, 0, true, fmt.Errorf("invalid index: %s", name)
+		}
+	}
+	return m, i, true, nil
+}
+
+// ピンの幅. 内部ピンと組み込み部品の値も含む
+func (s *Sim) Width(name string) (int, bool) {
+	if _, _, ok, err := s.memory(name); ok {
+		return 16, err == nil
+	}
+	nets, err := s.bus(name)
+	return len(nets), err == nil
+}
+
+// ピンの値を符号なしで返す
+func (s *Sim) Get(name string) (int, error) {
+	if m, i, ok, err := s.memory(name); ok {
+		if err != nil {
+			return 0, err
+		}
+		return m.Peek(i), nil
+	}
+
+	nets, err := s.bus(name)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, net := range nets {
+		if s.v[net] {
+			n |= 1 << i
+		}
+	}
+	return n, nil
+}
+
+// 入力ピンか組み込み部品の値を設定する. 出力に反映するには Eval か Tick, Tock を呼ぶ
+func (s *Sim) Set(name string, value int) error {
+	if m, i, ok, err := s.memory(name); ok {
+		if err != nil {
+			return err
+		}
+		m.Poke(i, value)
+		return nil
+	}
+
+	base, _, _ := strings.Cut(name, "[")
+	if s.pins[base] == nil {
+		return fmt.Errorf("unknown pin: %s", base)
+	}
+	if !s.inputs[base] {
+		return fmt.Errorf("%s is not an input pin", base)
+	}
+	nets, err := s.bus(name)
+	if err != nil {
+		return err
+	}
+	for i, net := range nets {
+		s.v[net] = value>>i&1 == 1
+	}
+	return nil
+}
+
+func (s *Sim) Eval() {
+	for _, c := range s.comps {
+		c.eval(s.v)
+	}
+}
+
+func (s *Sim) Tick() {
+	s.Eval()
+	for _, c := range s.clocked {
+		c.tick(s.v)
+	}
+	s.Eval()
+}
+
+func (s *Sim) Tock() {
+	for _, c := range s.clocked {
+		c.tock()
+	}
+	s.Eval()
+}
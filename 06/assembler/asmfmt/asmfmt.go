@@ -0,0 +1,210 @@
+package asmfmt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"assembler/code"
+	"assembler/parser"
+)
+
+const indent = "    "
+
+type line struct {
+	code    string
+	comment string
+	blank   bool
+}
+
+// 文字リテラル中の "//" はコメントとみなさない
+func splitComment(s string) (string, string) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'' && i+2 < len(s) && s[i+2] == '\'':
+			i += 2
+		case strings.HasPrefix(s[i:], "//"):
+			return s[:i], strings.TrimRight(s[i:], " \t\r")
+		}
+	}
+	return s, ""
+}
+
+func joinTokens(tokens []parser.Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+func joinArgs(s string, sep func(rune) bool) string {
+	args := strings.FieldsFunc(s, sep)
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return strings.Join(args, ", ")
+}
+
+func isComma(r rune) bool {
+	return r == ','
+}
+
+func formatDirective(s string) string {
+	dir, rest := parser.SplitHead(s)
+
+	switch dir {
+	case ".define", ".macro", ".data":
+		name, args := parser.SplitHead(rest)
+		if dir != ".define" {
+			args = joinArgs(args, isComma)
+		}
+		rest = strings.TrimSpace(name + " " + args)
+	case ".word":
+		rest = joinArgs(rest, isComma)
+	case ".global":
+		rest = joinArgs(rest, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+	}
+
+	return strings.TrimSpace(dir + " " + rest)
+}
+
+// 先頭が識別子で, C 命令として読めない行はマクロ呼び出しとみなす
+func macroCall(s string) (string, string, bool) {
+	name, args := parser.SplitHead(s)
+	if !parser.IsSymbol(name) || strings.ContainsAny(s, "=;") {
+		return "", "", false
+	}
+	if _, err := code.Comp(strings.Join(strings.Fields(s), "")); err == nil {
+		return "", "", false
+	}
+	return name, joinArgs(args, isComma), true
+}
+
+func formatCode(s string) (string, error) {
+	// .raw は命令として字下げする
+	if dir, rest := parser.SplitHead(s); dir == ".raw" {
+		return indent + strings.TrimSpace(dir+" "+rest), nil
+	}
+	if strings.HasPrefix(s, ".") {
+		return formatDirective(s), nil
+	}
+
+	if name, args, ok := macroCall(s); ok {
+		return indent + strings.TrimSpace(name+" "+args), nil
+	}
+
+	tokens, err := parser.Tokenize(s)
+	if err != nil {
+		var se *parser.SyntaxError
+		if errors.As(err, &se) {
+			return "", fmt.Errorf("column %d: %s", se.Column, se.Msg)
+		}
+		return "", err
+	}
+
+	switch tokens[0].Type {
+	case parser.TK_LPAREN:
+		if tokens[len(tokens)-1].Type != parser.TK_RPAREN {
+			return "", fmt.Errorf("malformed label: %s", s)
+		}
+		return "(" + strings.TrimSpace(s[1:len(s)-1]) + ")", nil
+
+	case parser.TK_AT:
+		return indent + "@" + strings.TrimSpace(s[1:]), nil
+	}
+
+	joined := joinTokens(tokens)
+	dest, rest, ok := strings.Cut(joined, "=")
+	if !ok {
+		dest, rest = "", joined
+	}
+	comp, jump, ok := strings.Cut(rest, ";")
+
+	var b strings.Builder
+	b.WriteString(indent)
+	if dest != "" {
+		b.WriteString(code.NormalizeDest(dest) + "=")
+	}
+	b.WriteString(comp)
+	if ok {
+		b.WriteString(";" + jump)
+	}
+	return b.String(), nil
+}
+
+// ラベルは行頭, 命令は 4 文字字下げ, 空行は 1 行にまとめ,
+// 続けて並んだ行の行末コメントは桁を揃える
+func Format(src []byte) ([]byte, error) {
+	var lines []line
+
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		raw := sc.Text()
+		c, comment := splitComment(raw)
+		c = strings.TrimSpace(c)
+
+		switch {
+		case c == "" && comment == "":
+			if len(lines) > 0 && !lines[len(lines)-1].blank {
+				lines = append(lines, line{blank: true})
+			}
+
+		case c == "":
+			// 字下げされていたコメント行は命令と同じ深さにする
+			if raw[0] == ' ' || raw[0] == '\t' {
+				comment = indent + comment
+			}
+			lines = append(lines, line{comment: comment})
+
+		default:
+			f, err := formatCode(c)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			lines = append(lines, line{code: f, comment: comment})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for len(lines) > 0 && lines[len(lines)-1].blank {
+		lines = lines[:len(lines)-1]
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < len(lines); {
+		if lines[i].code == "" {
+			b.WriteString(lines[i].comment)
+			b.WriteByte('\n')
+			i++
+			continue
+		}
+
+		j := i
+		width := 0
+		for ; j < len(lines) && lines[j].code != ""; j++ {
+			if lines[j].comment != "" {
+				width = max(width, len(lines[j].code))
+			}
+		}
+
+		for _, l := range lines[i:j] {
+			b.WriteString(l.code)
+			if l.comment != "" {
+				b.WriteString(strings.Repeat(" ", width-len(l.code)+1))
+				b.WriteString(l.comment)
+			}
+			b.WriteByte('\n')
+		}
+		i = j
+	}
+
+	return b.Bytes(), nil
+}
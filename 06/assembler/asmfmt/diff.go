@@ -0,0 +1,156 @@
+package asmfmt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// これより大きい差分は LCS を取らずに丸ごと置き換えとして出す
+	maxDiffCells = 1 << 24
+)
+
+type edit struct {
+	op   byte
+	text string
+}
+
+func splitLines(b []byte) []string {
+	s := strings.TrimSuffix(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func diffLines(x, y []string) []edit {
+	var edits []edit
+
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	for _, s := range x[:pre] {
+		edits = append(edits, edit{' ', s})
+	}
+
+	a, b := x[pre:len(x)-suf], y[pre:len(y)-suf]
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			edits = append(edits, edit{'-', s})
+		}
+		for _, s := range b {
+			edits = append(edits, edit{'+', s})
+		}
+	} else {
+		// lcs[i][j] は a[i:], b[j:] の最長共通部分列の長さ
+		lcs := make([][]int32, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				edits = append(edits, edit{' ', a[i]})
+				i++
+				j++
+			case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+				edits = append(edits, edit{'-', a[i]})
+				i++
+			default:
+				edits = append(edits, edit{'+', b[j]})
+				j++
+			}
+		}
+	}
+
+	for _, s := range x[len(x)-suf:] {
+		edits = append(edits, edit{' ', s})
+	}
+	return edits
+}
+
+// old と new の差分を unified 形式で書き出す. 差分がなければ false を返す
+func Diff(w io.Writer, name string, old, new []byte) (bool, error) {
+	edits := diffLines(splitLines(old), splitLines(new))
+
+	changed := false
+	for _, e := range edits {
+		if e.op != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "--- %s.orig\n+++ %s\n", name, name)
+
+	// 変更箇所の前後 diffContext 行ずつを 1 つの hunk にまとめる
+	oldLine, newLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for k, e := range edits {
+		oldLine[k+1], newLine[k+1] = oldLine[k], newLine[k]
+		if e.op != '+' {
+			oldLine[k+1]++
+		}
+		if e.op != '-' {
+			newLine[k+1]++
+		}
+	}
+
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+
+		start, last := max(0, k-diffContext), k
+		for end := k; end < len(edits) && end-last <= 2*diffContext; end++ {
+			if edits[end].op != ' ' {
+				last = end
+			}
+		}
+		end := min(len(edits), last+diffContext+1)
+
+		fmt.Fprintf(bw, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]),
+			hunkRange(newLine[start], newLine[end]),
+		)
+		for _, e := range edits[start:end] {
+			bw.WriteByte(e.op)
+			bw.WriteString(e.text)
+			bw.WriteByte('\n')
+		}
+		k = end
+	}
+
+	return true, bw.Flush()
+}
+
+// 空の範囲は直前の行番号で表す
+func hunkRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
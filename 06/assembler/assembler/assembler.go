@@ -0,0 +1,420 @@
+package assembler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"assembler/code"
+	"assembler/output"
+	"assembler/parser"
+	"assembler/symboltable"
+)
+
+const romSize = 32768
+
+type Options struct {
+	Name        string
+	IncludeDirs []string
+	Optimize    bool
+	FixedLabels []Symbol
+	FixedVars   []Symbol
+}
+
+type Instruction struct {
+	Address int
+	Word    uint16
+	// マクロ展開された命令は呼び出した行を指す
+	File    string
+	LineNum int
+	Source  string
+	Symbols []string
+}
+
+type Symbol struct {
+	Name    string
+	Address int
+}
+
+type Program struct {
+	Words           []uint16
+	Instructions    []Instruction
+	Labels          []Symbol
+	Vars            []Symbol
+	Data            []DataBlock
+	Warnings        []*Warning
+	UnoptimizedSize int
+	StartupSize     int
+
+	// 番地 -> そこに置かれたラベル (Labels と同じ順)
+	labelsAt map[int][]string
+}
+
+func (prog Program) LabelsAt(address int) []string {
+	return prog.labelsAt[address]
+}
+
+func (prog Program) WriteHack(w io.Writer) error {
+	return output.Write(w, output.HACK, prog.Words)
+}
+
+type columns struct {
+	symbol int
+	dest   int
+	comp   int
+	jump   int
+}
+
+// 構文解析済みの 1 行分
+type item struct {
+	ty      parser.InstructionType
+	label   string
+	operand string
+	dest    string
+	comp    string
+	jump    string
+	source  string
+	loc     location
+	cols    columns
+}
+
+type assembler struct {
+	opts  Options
+	st    *symboltable.SymbolTable
+	prog  Program
+	errs  ErrorList
+	src   []srcLine
+	items []*item
+
+	locs      []location
+	labelLocs map[string]location
+	fixed     map[string]bool
+	dataVars  []Symbol
+
+	// ファイル名 -> 読み込んだ順 (.include を含む)
+	order map[string]int
+	// リンクで付けた名前 -> ソースに書かれた名前
+	srcNames map[string]string
+}
+
+func (a *assembler) errorAt(l location, col int, err error) {
+	a.errs = append(a.errs, &Error{
+		Name:   l.origin.name,
+		Line:   l.origin.line,
+		Column: col,
+		Source: l.source,
+		Err:    err,
+	})
+}
+
+// エラーと警告をファイルを読んだ順, 行, 桁の順に並べる
+func (a *assembler) comparePos(xName string, xLine, xCol int, yName string, yLine, yCol int) int {
+	if xName != yName {
+		return a.order[xName] - a.order[yName]
+	}
+	if xLine != yLine {
+		return xLine - yLine
+	}
+	return xCol - yCol
+}
+
+func (a *assembler) parse(src []byte) {
+	p := parser.New(bytes.NewReader(src))
+	p.Advance()
+	for p.HasMoreLines() {
+		sl := a.src[p.SourceLineNum()-1]
+		loc := location{
+			origin: sl.origin,
+			site:   sl.site(),
+			column: p.Column(),
+			source: p.RawLine(),
+			nowarn: sl.nowarn,
+		}
+
+		if err := p.Err(); err != nil {
+			col := 1
+			var se *parser.SyntaxError
+			if errors.As(err, &se) {
+				col = se.Column
+			}
+			a.errorAt(loc, col, err)
+			p.Advance()
+			continue
+		}
+
+		it := &item{
+			ty:     p.InstructionType(),
+			source: p.Line(),
+			loc:    loc,
+			cols: columns{
+				symbol: p.SymbolColumn(),
+				dest:   p.DestColumn(),
+				comp:   p.CompColumn(),
+				jump:   p.JumpColumn(),
+			},
+		}
+
+		switch it.ty {
+		case parser.L_INSTRUCTION:
+			sb, err := p.Symbol(nil)
+			if err != nil {
+				a.errorAt(it.loc, it.cols.symbol, err)
+				p.Advance()
+				continue
+			}
+			it.label = sb
+
+		case parser.A_INSTRUCTION, parser.RAW_INSTRUCTION:
+			it.operand = p.Operand()
+
+		case parser.C_INSTRUCTION:
+			it.dest = p.Dest()
+			it.comp = p.Comp()
+			it.jump = p.Jump()
+		}
+
+		a.items = append(a.items, it)
+		p.Advance()
+	}
+}
+
+func (a *assembler) defineLabels() {
+	addr := 0
+	for _, it := range a.items {
+		if it.ty != parser.L_INSTRUCTION {
+			addr++
+			continue
+		}
+
+		if a.st.Contains(it.label) && !a.fixed[it.label] {
+			a.errorAt(it.loc, it.cols.symbol, fmt.Errorf("duplicate symbol: %s", it.label))
+			continue
+		}
+
+		a.st.AddEntry(it.label, addr)
+		a.prog.Labels = append(a.prog.Labels, Symbol{it.label, addr})
+		a.prog.labelsAt[addr] = append(a.prog.labelsAt[addr], it.label)
+		l := it.loc
+		l.column = it.cols.symbol
+		a.labelLocs[it.label] = l
+	}
+}
+
+func (a *assembler) encode(it *item) uint16 {
+	switch it.ty {
+	case parser.A_INSTRUCTION:
+		sb, err := parser.Resolve(it.operand, a.st)
+		if err != nil {
+			col := it.cols.symbol
+			var ee *parser.ExprError
+			if errors.As(err, &ee) {
+				col += ee.Offset
+			}
+			a.errorAt(it.loc, col, err)
+			return 0
+		}
+
+		n, err := strconv.ParseUint(sb, 10, 15)
+		if err != nil {
+			a.errorAt(it.loc, it.cols.symbol, fmt.Errorf("address out of range: %s", sb))
+			return 0
+		}
+
+		return uint16(n)
+
+	case parser.C_INSTRUCTION:
+		ok := true
+
+		comp, err := code.Comp(it.comp)
+		if err != nil {
+			a.errorAt(it.loc, it.cols.comp, err)
+			ok = false
+		}
+
+		dest, err := code.Dest(it.dest)
+		if err != nil {
+			a.errorAt(it.loc, it.cols.dest, err)
+			ok = false
+		}
+
+		jump, err := code.Jump(it.jump)
+		if err != nil {
+			a.errorAt(it.loc, it.cols.jump, err)
+			ok = false
+		}
+
+		if !ok {
+			return 0
+		}
+
+		n, _ := strconv.ParseUint("111"+comp+dest+jump, 2, 16)
+		return uint16(n)
+
+	case parser.RAW_INSTRUCTION:
+		if it.operand == "" {
+			a.errorAt(it.loc, it.cols.symbol, fmt.Errorf("missing value for .raw"))
+			return 0
+		}
+		w, err := parser.EvalWord(it.operand, a.st)
+		if err != nil {
+			col := it.cols.symbol
+			var ee *parser.ExprError
+			if errors.As(err, &ee) {
+				col += ee.Offset
+			}
+			a.errorAt(it.loc, col, err)
+			return 0
+		}
+		return uint16(w)
+	}
+
+	return 0
+}
+
+func (a *assembler) translate() {
+	for _, it := range a.items {
+		if it.ty == parser.L_INSTRUCTION {
+			continue
+		}
+
+		// エラーがあってもアドレスがずれないよう 0 を埋めておく
+		word := a.encode(it)
+
+		a.prog.Instructions = append(a.prog.Instructions, Instruction{
+			Address: len(a.prog.Words),
+			Word:    word,
+			File:    it.loc.site.name,
+			LineNum: it.loc.site.line,
+			Source:  it.source,
+			Symbols: identifiers(it.operand),
+		})
+		a.prog.Words = append(a.prog.Words, word)
+		a.locs = append(a.locs, it.loc)
+	}
+
+	a.prog.Vars = append(a.prog.Vars, a.opts.FixedVars...)
+	a.prog.Vars = append(a.prog.Vars, a.dataVars...)
+	for _, v := range a.st.Vars() {
+		addr, _ := a.st.GetAddress(v)
+		a.prog.Vars = append(a.prog.Vars, Symbol{v, addr})
+	}
+}
+
+// ソース中のラベル定義は .sym から読んだラベルより優先する
+func (a *assembler) seed() {
+	for _, s := range a.opts.FixedLabels {
+		a.st.AddEntry(s.Name, s.Address)
+		a.fixed[s.Name] = true
+	}
+	for _, s := range a.opts.FixedVars {
+		a.st.AddFixed(s.Name, s.Address)
+	}
+}
+
+func (a *assembler) size() int {
+	n := 0
+	for _, it := range a.items {
+		if it.ty != parser.L_INSTRUCTION {
+			n++
+		}
+	}
+	return n
+}
+
+type Source struct {
+	Name string
+	Data []byte
+}
+
+func Assemble(r io.Reader, opts Options) (Program, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return Program{}, err
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = "<input>"
+	}
+
+	return AssembleFiles([]Source{{name, src}}, opts)
+}
+
+// 複数のソースをそれぞれ別の単位として読み, 1 つの ROM にリンクする.
+// ラベルは .global で公開しない限りファイル内に閉じる
+func AssembleFiles(srcs []Source, opts Options) (Program, error) {
+	a := &assembler{
+		opts:      opts,
+		st:        symboltable.New(),
+		labelLocs: make(map[string]location),
+		fixed:     make(map[string]bool),
+		order:     make(map[string]int),
+		srcNames:  make(map[string]string),
+	}
+	a.prog.labelsAt = make(map[int][]string)
+	a.seed()
+
+	var units []unit
+	var data []*dataBlock
+	for _, src := range srcs {
+		pp := newPreprocessor(opts, a.order)
+		pp.file(src.Name, src.Data)
+		a.errs = append(a.errs, pp.errs...)
+
+		start := len(a.items)
+		a.src = pp.out
+		a.parse(pp.source())
+		units = append(units, unit{
+			name:    src.Name,
+			items:   a.items[start:],
+			globals: pp.globals,
+			data:    pp.data,
+		})
+	}
+	if len(units) > 1 {
+		a.link(units)
+	}
+	for _, u := range units {
+		data = append(data, u.data...)
+	}
+	if len(data) > 0 {
+		a.layoutData(data)
+	}
+
+	a.prog.UnoptimizedSize = a.size()
+	if opts.Optimize && len(a.errs) == 0 {
+		if i, why, ok := fixedAddress(a.items); ok {
+			// 起動ルーチンの命令は警告しない設定になっているが, これは .data に書いた式のせい
+			l := a.items[i].loc
+			if i < a.prog.StartupSize {
+				l.nowarn = ""
+			} else {
+				l.column = a.items[i].cols.symbol
+			}
+			a.warnAt(l, WARN_OPT_SKIPPED, "optimization skipped: %s", why)
+		} else {
+			a.items = optimize(a.items)
+		}
+	}
+
+	a.defineLabels()
+	a.translate()
+	// 出力形式によっては番地が折り返して先頭を上書きしてしまう
+	if n := len(a.prog.Words); n > romSize {
+		l := a.locs[romSize]
+		a.errorAt(l, l.column, fmt.Errorf("program too large: %d words (ROM has %d)", n, romSize))
+	}
+
+	if len(a.errs) > 0 {
+		slices.SortStableFunc(a.errs, func(x, y *Error) int {
+			return a.comparePos(x.Name, x.Line, x.Column, y.Name, y.Line, y.Column)
+		})
+		return Program{}, a.errs
+	}
+
+	a.warn()
+	return a.prog, nil
+}
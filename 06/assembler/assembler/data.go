@@ -0,0 +1,197 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"assembler/parser"
+	"assembler/symboltable"
+)
+
+const dataBase = 16
+
+type DataBlock struct {
+	Name    string
+	Address int
+	Words   int
+}
+
+type dataWord struct {
+	expr   string
+	line   srcLine
+	column int
+}
+
+type dataBlock struct {
+	name  string
+	line  srcLine
+	words []dataWord
+}
+
+// 起動ルーチンを組み立てる間, A と D に入っている値を覚えておく
+type startup struct {
+	items  []*item
+	a, d   int
+	aKnown bool
+	dKnown bool
+}
+
+func (s *startup) emit(w dataWord, text string) {
+	it := &item{
+		source: text,
+		loc: location{
+			origin: w.line.origin,
+			site:   w.line.site(),
+			column: w.column,
+			source: w.line.text,
+			nowarn: "*",
+		},
+		cols: columns{symbol: w.column, dest: w.column, comp: w.column, jump: w.column},
+	}
+
+	if text[0] == '@' {
+		it.ty = parser.A_INSTRUCTION
+		it.operand = text[1:]
+	} else {
+		it.ty = parser.C_INSTRUCTION
+		it.dest, it.comp = text[:1], text[2:]
+	}
+	s.items = append(s.items, it)
+}
+
+func (s *startup) setA(w dataWord, addr int) {
+	switch {
+	case s.aKnown && s.a == addr:
+		return
+	case s.aKnown && s.a == addr-1:
+		s.emit(w, "A=A+1")
+	case s.aKnown && s.a == addr+1:
+		s.emit(w, "A=A-1")
+	default:
+		s.emit(w, "@"+strconv.Itoa(addr))
+	}
+	s.a, s.aKnown = addr, true
+}
+
+func (s *startup) store(w dataWord, addr int, v int16) {
+	if v == 0 || v == 1 || v == -1 {
+		s.setA(w, addr)
+		s.emit(w, "M="+strconv.Itoa(int(v)))
+		return
+	}
+
+	if !s.dKnown || s.d != int(v) {
+		if v >= 0 {
+			s.emit(w, "@"+strconv.Itoa(int(v)))
+			s.emit(w, "D=A")
+			s.a = int(v)
+		} else {
+			s.emit(w, "@"+strconv.Itoa(int(^v)))
+			s.emit(w, "D=!A")
+			s.a = int(^v)
+		}
+		s.aKnown = true
+		s.d, s.dKnown = int(v), true
+	}
+	s.setA(w, addr)
+	s.emit(w, "M=D")
+}
+
+// ラベルを含む値は番地が決まるまで評価できないので式のまま残す
+func (s *startup) storeExpr(w dataWord, addr int) {
+	s.emit(w, "@("+w.expr+")")
+	s.items[len(s.items)-1].cols.symbol--
+	s.emit(w, "D=A")
+	s.aKnown, s.dKnown = false, false
+	s.setA(w, addr)
+	s.emit(w, "M=D")
+}
+
+func allDefined(st *symboltable.SymbolTable, expr string) bool {
+	for _, s := range identifiers(expr) {
+		if !st.Contains(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *assembler) allocData(b *dataBlock) (int, bool) {
+	for _, v := range a.opts.FixedVars {
+		if v.Name == b.name {
+			return v.Address, true
+		}
+	}
+
+	base := dataBase
+	for i := 0; i < len(b.words); i++ {
+		if a.st.Reserved(base + i) {
+			base += i + 1
+			i = -1
+		}
+	}
+	return base, false
+}
+
+// .data の値を RAM に書き込む起動ルーチンを生成してプログラムの先頭に置く.
+// ルーチンの最後からそのまま元のプログラムの先頭に落ちる
+func (a *assembler) layoutData(blocks []*dataBlock) {
+	consts := symboltable.New()
+	s := &startup{}
+
+	for _, b := range blocks {
+		col := indentOf(b.line.text) + 1
+		loc := location{origin: b.line.origin, source: b.line.text}
+		if len(b.words) == 0 {
+			a.errorAt(loc, col, fmt.Errorf("empty .data block: %s", b.name))
+			continue
+		}
+
+		base, pinned := a.allocData(b)
+		if a.st.Contains(b.name) && !pinned {
+			a.errorAt(loc, col, fmt.Errorf("duplicate symbol: %s", b.name))
+			continue
+		}
+		if end := base + len(b.words); end > screenBase {
+			a.errorAt(loc, col, fmt.Errorf(
+				".data %s (%d words at %d) overlaps SCREEN", b.name, len(b.words), base,
+			))
+			continue
+		}
+
+		a.st.AddFixed(b.name, base)
+		consts.AddEntry(b.name, base)
+		for i := range b.words {
+			a.st.Reserve(base + i)
+		}
+		a.prog.Data = append(a.prog.Data, DataBlock{b.name, base, len(b.words)})
+		if !pinned {
+			a.dataVars = append(a.dataVars, Symbol{b.name, base})
+		}
+
+		for i, w := range b.words {
+			v, err := parser.EvalWord(w.expr, consts)
+			if err == nil {
+				s.store(w, base+i, v)
+				continue
+			}
+
+			if !allDefined(consts, w.expr) {
+				s.storeExpr(w, base+i)
+				continue
+			}
+
+			wl := location{origin: w.line.origin, source: w.line.text}
+			col := w.column
+			var ee *parser.ExprError
+			if errors.As(err, &ee) {
+				col += ee.Offset
+			}
+			a.errorAt(wl, col, err)
+		}
+	}
+
+	a.prog.StartupSize = len(s.items)
+	a.items = append(s.items, a.items...)
+}
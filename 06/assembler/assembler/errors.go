@@ -0,0 +1,55 @@
+package assembler
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Error struct {
+	Name   string
+	Line   int
+	Column int
+	Source string
+	Err    error
+}
+
+func format(name string, line, col int, source, msg string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:%d:%d: %s", name, line, col, msg)
+
+	if source == "" {
+		return b.String()
+	}
+
+	// タブはそのまま残してキャレットの位置を揃える
+	var pad strings.Builder
+	for i := 0; i < col-1 && i < len(source); i++ {
+		if source[i] == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+
+	fmt.Fprintf(&b, "\n\t%s\n\t%s^", source, pad.String())
+	return b.String()
+}
+
+func (e *Error) Error() string {
+	return format(e.Name, e.Line, e.Column, e.Source, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type ErrorList []*Error
+
+func (el ErrorList) Error() string {
+	msgs := make([]string, len(el))
+	for i, e := range el {
+		msgs[i] = e.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
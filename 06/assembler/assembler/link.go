@@ -0,0 +1,92 @@
+package assembler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"assembler/parser"
+)
+
+type unit struct {
+	name    string
+	items   []*item
+	globals map[string]srcLine
+	data    []*dataBlock
+}
+
+// ファイル内ラベルに付ける接頭辞 (例: "os.asm" の LOOP は "os:LOOP")
+func (u unit) prefix() string {
+	base := filepath.Base(u.name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	if base == "" || !parser.IsSymbolRune(base[0], true) {
+		base = "_" + base
+	}
+	return base + ":"
+}
+
+func (a *assembler) link(units []unit) {
+	// 公開されていないラベル -> 定義しているユニット
+	localOwner := make(map[string]string)
+	exported := make(map[string]bool)
+
+	renames := make([]map[string]string, len(units))
+	for i, u := range units {
+		renames[i] = make(map[string]string)
+
+		defined := make(map[string]bool)
+		for _, it := range u.items {
+			if it.ty != parser.L_INSTRUCTION {
+				continue
+			}
+			defined[it.label] = true
+
+			if _, ok := u.globals[it.label]; ok {
+				exported[it.label] = true
+				continue
+			}
+			renames[i][it.label] = u.prefix() + it.label
+			a.srcNames[u.prefix()+it.label] = it.label
+			localOwner[it.label] = u.name
+		}
+
+		for name, l := range u.globals {
+			if !defined[name] {
+				a.errorAt(
+					location{origin: l.origin, source: l.text},
+					indentOf(l.text)+1,
+					fmt.Errorf(".global label %s is not defined in %s", name, u.name),
+				)
+			}
+		}
+	}
+
+	for i, u := range units {
+		for _, it := range u.items {
+			switch it.ty {
+			case parser.L_INSTRUCTION:
+				if r, ok := renames[i][it.label]; ok {
+					it.label = r
+				}
+
+			case parser.A_INSTRUCTION, parser.RAW_INSTRUCTION:
+				for _, s := range identifiers(it.operand) {
+					_, local := renames[i][s]
+					owner, elsewhere := localOwner[s]
+					if !local && !exported[s] && elsewhere {
+						a.errorAt(it.loc, it.cols.symbol, fmt.Errorf(
+							"label %s is local to %s (missing .global?)", s, owner,
+						))
+					}
+				}
+				it.operand = substitute(it.operand, renames[i])
+			}
+		}
+
+		for _, b := range u.data {
+			for j := range b.words {
+				b.words[j].expr = substitute(b.words[j].expr, renames[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package assembler
+
+import (
+	"strings"
+
+	"assembler/parser"
+)
+
+const maxOptimizePasses = 16
+
+// .raw の値は何を読み書きするかわからないので, 全てを読み書きするとみなす
+func (it *item) writes(r string) bool {
+	return it.ty == parser.RAW_INSTRUCTION || (it.ty == parser.C_INSTRUCTION && strings.Contains(it.dest, r))
+}
+
+func (it *item) reads(r string) bool {
+	return it.ty == parser.RAW_INSTRUCTION || (it.ty == parser.C_INSTRUCTION && strings.Contains(it.comp, r))
+}
+
+// labels を飛ばして i 以降で最初の命令の位置を返す
+func nextInstruction(items []*item, i int) int {
+	for ; i < len(items); i++ {
+		if items[i].ty != parser.L_INSTRUCTION {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeMarked(items []*item, dead []bool) ([]*item, bool) {
+	changed := false
+	out := items[:0]
+	for i, it := range items {
+		if dead[i] {
+			changed = true
+			continue
+		}
+		out = append(out, it)
+	}
+	return out, changed
+}
+
+// 直前と同じ値を A に読み込む @ 命令を取り除く
+func removeRedundantLoads(items []*item) ([]*item, bool) {
+	dead := make([]bool, len(items))
+	known := ""
+	for i, it := range items {
+		switch it.ty {
+		case parser.L_INSTRUCTION:
+			known = ""
+		case parser.A_INSTRUCTION:
+			if it.operand == known {
+				dead[i] = true
+			}
+			known = it.operand
+		case parser.C_INSTRUCTION, parser.RAW_INSTRUCTION:
+			if it.writes("A") {
+				known = ""
+			}
+		}
+	}
+
+	return removeMarked(items, dead)
+}
+
+// 使われる前に上書きされる A, D, M への書き込みを取り除く
+func removeDeadStores(items []*item) ([]*item, bool) {
+	dead := make([]bool, len(items))
+	for i, it := range items {
+		j := nextInstruction(items, i+1)
+		if j == -1 {
+			break
+		}
+		next := items[j]
+
+		switch it.ty {
+		case parser.A_INSTRUCTION:
+			if next.ty == parser.A_INSTRUCTION {
+				dead[i] = true
+			}
+
+		case parser.C_INSTRUCTION:
+			if it.jump != "" || (it.dest != "D" && it.dest != "M") {
+				continue
+			}
+			if next.writes(it.dest) && !next.reads(it.dest) {
+				dead[i] = true
+			}
+		}
+	}
+
+	return removeMarked(items, dead)
+}
+
+// M=M+1 の直後の M=M-1 のように打ち消し合う組を取り除く
+func removeCancellingPairs(items []*item) ([]*item, bool) {
+	dead := make([]bool, len(items))
+	for i := 0; i+1 < len(items); i++ {
+		x, y := items[i], items[i+1]
+		if x.ty != parser.C_INSTRUCTION || y.ty != parser.C_INSTRUCTION {
+			continue
+		}
+		if x.jump != "" || y.jump != "" || x.dest != y.dest {
+			continue
+		}
+		if x.dest != "D" && x.dest != "M" {
+			continue
+		}
+
+		inc, dec := x.dest+"+1", x.dest+"-1"
+		if (x.comp == inc && y.comp == dec) || (x.comp == dec && y.comp == inc) {
+			dead[i], dead[i+1] = true, true
+			i++
+		}
+	}
+
+	return removeMarked(items, dead)
+}
+
+// ジャンプ先がさらに無条件ジャンプだけなら最終的な飛び先に付け替える
+func collapseJumpChains(items []*item) ([]*item, bool) {
+	forward := make(map[string]string)
+	for i, it := range items {
+		if it.ty != parser.L_INSTRUCTION {
+			continue
+		}
+
+		j := nextInstruction(items, i+1)
+		if j == -1 || j+1 >= len(items) {
+			continue
+		}
+		at, jmp := items[j], items[j+1]
+		if at.ty != parser.A_INSTRUCTION || !parser.IsSymbol(at.operand) {
+			continue
+		}
+		if jmp.ty != parser.C_INSTRUCTION || jmp.dest != "" || jmp.jump != "JMP" {
+			continue
+		}
+		forward[it.label] = at.operand
+	}
+
+	resolve := func(label string) string {
+		seen := map[string]bool{label: true}
+		for {
+			next, ok := forward[label]
+			if !ok || seen[next] {
+				return label
+			}
+			seen[next] = true
+			label = next
+		}
+	}
+
+	changed := false
+	for i, it := range items {
+		if it.ty != parser.A_INSTRUCTION || i+1 >= len(items) {
+			continue
+		}
+		if _, ok := forward[it.operand]; !ok {
+			continue
+		}
+
+		jmp := items[i+1]
+		if jmp.ty != parser.C_INSTRUCTION || jmp.jump == "" {
+			continue
+		}
+		if jmp.reads("A") || jmp.reads("M") || jmp.writes("M") {
+			continue
+		}
+
+		// 条件ジャンプで飛ばなかった場合に A の値が使われないこと
+		if jmp.jump != "JMP" {
+			k := nextInstruction(items, i+2)
+			if k == -1 || items[k].ty != parser.A_INSTRUCTION {
+				continue
+			}
+		}
+
+		if target := resolve(it.operand); target != it.operand {
+			it.operand = target
+			it.source = "@" + target
+			changed = true
+		}
+	}
+
+	return items, changed
+}
+
+// 命令を取り除くと番地がずれるので, ラベル名で書かれていない ROM 番地があれば
+// 最適化できない. 数値のジャンプ先とラベルを含む式を探し, その位置を返す
+func fixedAddress(items []*item) (int, string, bool) {
+	labels := make(map[string]bool)
+	for _, it := range items {
+		if it.ty == parser.L_INSTRUCTION {
+			labels[it.label] = true
+		}
+	}
+
+	for i, it := range items {
+		if it.ty != parser.A_INSTRUCTION && it.ty != parser.RAW_INSTRUCTION {
+			continue
+		}
+		// .data の起動ルーチンはラベルを "(LABEL)" の形で読み込む
+		if parser.IsSymbol(strings.Trim(it.operand, "() ")) {
+			continue
+		}
+
+		for _, s := range identifiers(it.operand) {
+			if labels[s] {
+				return i, "expression " + it.operand + " refers to label " + s, true
+			}
+		}
+
+		j := nextInstruction(items, i+1)
+		if it.ty == parser.A_INSTRUCTION && j != -1 && items[j].ty == parser.C_INSTRUCTION && items[j].jump != "" {
+			return i, "jump to numeric address " + it.operand, true
+		}
+	}
+	return 0, "", false
+}
+
+func optimize(items []*item) []*item {
+	passes := []func([]*item) ([]*item, bool){
+		collapseJumpChains,
+		removeRedundantLoads,
+		removeCancellingPairs,
+		removeDeadStores,
+	}
+
+	for range maxOptimizePasses {
+		changed := false
+		for _, pass := range passes {
+			var c bool
+			items, c = pass(items)
+			changed = changed || c
+		}
+		if !changed {
+			break
+		}
+	}
+
+	return items
+}
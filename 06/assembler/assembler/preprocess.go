@@ -0,0 +1,491 @@
+package assembler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"assembler/parser"
+)
+
+const maxExpandDepth = 64
+
+type origin struct {
+	name string
+	line int
+}
+
+type srcLine struct {
+	text   string
+	origin origin
+	nowarn string
+	// マクロ展開で生まれた行なら, 一番外側の呼び出しの位置
+	call origin
+}
+
+// リストに載せる位置. マクロの中の行は呼び出した行にまとめる
+func (l srcLine) site() origin {
+	if l.call.name != "" {
+		return l.call
+	}
+	return l.origin
+}
+
+type macro struct {
+	name   string
+	params []string
+	labels []string
+	body   []srcLine
+	origin origin
+}
+
+type preprocessor struct {
+	opts    Options
+	defines map[string]string
+	macros  map[string]*macro
+	cur     *macro
+	count   int
+	files   []string
+	order   map[string]int
+	out     []srcLine
+	errs    ErrorList
+	nowarn  string
+	globals map[string]srcLine
+	data    []*dataBlock
+}
+
+func newPreprocessor(opts Options, order map[string]int) *preprocessor {
+	return &preprocessor{
+		opts:    opts,
+		order:   order,
+		defines: make(map[string]string),
+		macros:  make(map[string]*macro),
+		globals: make(map[string]srcLine),
+	}
+}
+
+func (pp *preprocessor) errorAt(l srcLine, col int, err error) {
+	pp.errs = append(pp.errs, &Error{
+		Name:   l.origin.name,
+		Line:   l.origin.line,
+		Column: col,
+		Source: l.text,
+		Err:    err,
+	})
+}
+
+// 識別子単位で置換する (コメントと文字リテラルの中は置換しない)
+func substitute(s string, m map[string]string) string {
+	if len(m) == 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], "//"):
+			b.WriteString(s[i:])
+			return b.String()
+
+		case c == '\'' && i+2 < len(s) && s[i+2] == '\'':
+			b.WriteString(s[i : i+3])
+			i += 3
+
+		case '0' <= c && c <= '9':
+			j := i
+			for j < len(s) && parser.IsSymbolRune(s[j], false) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+
+		case parser.IsSymbolRune(c, true):
+			j := i
+			for j < len(s) && parser.IsSymbolRune(s[j], false) {
+				j++
+			}
+			if v, ok := m[s[i:j]]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(s[i:j])
+			}
+			i = j
+
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+func identifiers(s string) []string {
+	var idents []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\'' && i+2 < len(s) && s[i+2] == '\'':
+			i += 3
+
+		case parser.IsSymbolRune(c, false):
+			j := i
+			for j < len(s) && parser.IsSymbolRune(s[j], false) {
+				j++
+			}
+			if parser.IsSymbolRune(c, true) {
+				idents = append(idents, s[i:j])
+			}
+			i = j
+
+		default:
+			i++
+		}
+	}
+
+	return idents
+}
+
+func stripComment(s string) string {
+	if i := strings.Index(s, "//"); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func indentOf(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " \t"))
+}
+
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	args := strings.Split(s, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
+
+func (pp *preprocessor) resolveInclude(from, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	dirs := []string{"."}
+	if from != "" && !strings.HasPrefix(from, "<") {
+		dirs[0] = filepath.Dir(from)
+	}
+	dirs = append(dirs, pp.opts.IncludeDirs...)
+
+	for _, dir := range dirs {
+		p := filepath.Join(dir, path)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("include file not found: %s", path)
+}
+
+func (pp *preprocessor) file(name string, src []byte) {
+	pp.files = append(pp.files, name)
+	if _, ok := pp.order[name]; !ok {
+		pp.order[name] = len(pp.order)
+	}
+	defer func() { pp.files = pp.files[:len(pp.files)-1] }()
+
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		pp.line(srcLine{text: sc.Text(), origin: origin{name, lineNum}}, 0)
+	}
+
+	if pp.cur != nil && len(pp.files) == 1 {
+		l := srcLine{origin: pp.cur.origin}
+		pp.errorAt(l, 1, fmt.Errorf("missing .endm for macro %s", pp.cur.name))
+		pp.cur = nil
+	}
+}
+
+func (pp *preprocessor) directive(l srcLine) {
+	col := indentOf(l.text) + 1
+	dir, rest := parser.SplitHead(stripComment(l.text))
+
+	if pp.cur != nil {
+		switch dir {
+		case ".endm":
+			m := pp.cur
+			pp.cur = nil
+			pp.macros[m.name] = m
+		case ".macro":
+			pp.errorAt(l, col, fmt.Errorf("nested .macro in %s", pp.cur.name))
+		default:
+			pp.cur.body = append(pp.cur.body, l)
+		}
+		return
+	}
+
+	switch dir {
+	case ".define":
+		name, value := parser.SplitHead(rest)
+		if !parser.IsSymbol(name) {
+			pp.errorAt(l, col, fmt.Errorf("invalid .define name: %q", name))
+			return
+		}
+		if value == "" {
+			pp.errorAt(l, col, fmt.Errorf("missing value for .define %s", name))
+			return
+		}
+		pp.defines[name] = substitute(value, pp.defines)
+
+	case ".macro":
+		name, params := parser.SplitHead(rest)
+		if !parser.IsSymbol(name) {
+			pp.errorAt(l, col, fmt.Errorf("invalid macro name: %q", name))
+			return
+		}
+		if _, ok := pp.macros[name]; ok {
+			pp.errorAt(l, col, fmt.Errorf("duplicate macro: %s", name))
+			return
+		}
+
+		m := &macro{name: name, params: splitArgs(params), origin: l.origin}
+		for _, p := range m.params {
+			if !parser.IsSymbol(p) {
+				pp.errorAt(l, col, fmt.Errorf("invalid macro parameter: %q", p))
+				return
+			}
+		}
+		pp.cur = m
+
+	case ".endm":
+		pp.errorAt(l, col, fmt.Errorf(".endm without .macro"))
+
+	case ".global":
+		names := strings.FieldsFunc(rest, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(names) == 0 {
+			pp.errorAt(l, col, fmt.Errorf("missing label name for .global"))
+			return
+		}
+		for _, name := range names {
+			if !parser.IsSymbol(name) {
+				pp.errorAt(l, col, fmt.Errorf("invalid .global name: %q", name))
+				continue
+			}
+			pp.globals[name] = l
+		}
+
+	case ".data":
+		name, values := parser.SplitHead(rest)
+		if !parser.IsSymbol(name) {
+			pp.errorAt(l, col, fmt.Errorf("invalid .data name: %q", name))
+			return
+		}
+		pp.data = append(pp.data, &dataBlock{name: name, line: l})
+		pp.words(l, values)
+
+	case ".word":
+		if len(pp.data) == 0 {
+			pp.errorAt(l, col, fmt.Errorf(".word without .data"))
+			return
+		}
+		pp.words(l, rest)
+
+	case ".raw":
+		pp.out = append(pp.out, srcLine{
+			text:   substitute(l.text, pp.defines),
+			origin: l.origin,
+			nowarn: pp.nowarn,
+			call:   l.call,
+		})
+		pp.nowarn = ""
+
+	case ".include":
+		path, err := unquote(rest)
+		if err != nil {
+			pp.errorAt(l, col, err)
+			return
+		}
+
+		path, err = pp.resolveInclude(l.origin.name, path)
+		if err != nil {
+			pp.errorAt(l, col, err)
+			return
+		}
+		for _, f := range pp.files {
+			if f == path {
+				pp.errorAt(l, col, fmt.Errorf("recursive include: %s", path))
+				return
+			}
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			pp.errorAt(l, col, err)
+			return
+		}
+		pp.file(path, src)
+
+	default:
+		pp.errorAt(l, col, fmt.Errorf("unknown directive: %s", dir))
+	}
+}
+
+func (pp *preprocessor) words(l srcLine, values string) {
+	b := pp.data[len(pp.data)-1]
+	text := l.text
+	if i := strings.Index(text, "//"); i != -1 {
+		text = text[:i]
+	}
+	text = strings.TrimRight(text, " \t")
+
+	// 値の桁位置は元の行から探す
+	pos := len(text) - len(values)
+	for _, v := range splitArgs(values) {
+		col := strings.Index(text[pos:], v) + pos + 1
+		pos = col - 1 + len(v)
+		if v == "" {
+			pp.errorAt(l, col, fmt.Errorf("missing value in .word"))
+			continue
+		}
+		b.words = append(b.words, dataWord{
+			expr:   substitute(v, pp.defines),
+			line:   l,
+			column: col,
+		})
+	}
+}
+
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("expected quoted file name: %s", s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+func labelOf(text string) (string, bool) {
+	t := stripComment(text)
+	if len(t) < 2 || t[0] != '(' || t[len(t)-1] != ')' {
+		return "", false
+	}
+	return t[1 : len(t)-1], true
+}
+
+func (pp *preprocessor) expand(m *macro, l srcLine, args []string, depth int) {
+	col := indentOf(l.text) + 1
+	if len(args) != len(m.params) {
+		pp.errorAt(l, col, fmt.Errorf(
+			"macro %s expects %d argument(s), got %d",
+			m.name, len(m.params), len(args),
+		))
+		return
+	}
+	if depth >= maxExpandDepth {
+		pp.errorAt(l, col, fmt.Errorf("macro expansion too deep: %s", m.name))
+		return
+	}
+
+	pp.count++
+	subst := make(map[string]string, len(m.params)+len(m.labels))
+	for i, p := range m.params {
+		subst[p] = args[i]
+	}
+	for _, lb := range m.labels {
+		subst[lb] = fmt.Sprintf("%s$%s.%d", m.name, lb, pp.count)
+	}
+
+	for _, bl := range m.body {
+		pp.line(srcLine{text: substitute(bl.text, subst), origin: bl.origin, call: l.site()}, depth+1)
+	}
+}
+
+func (pp *preprocessor) line(l srcLine, depth int) {
+	text := stripComment(l.text)
+
+	if strings.HasPrefix(text, ".") {
+		pp.directive(l)
+		return
+	}
+
+	if pp.cur != nil {
+		if lb, ok := labelOf(l.text); ok && !strings.Contains(lb, " ") {
+			pp.cur.labels = append(pp.cur.labels, lb)
+		}
+		pp.cur.body = append(pp.cur.body, l)
+		return
+	}
+
+	if text == "" {
+		if nw, ok := nowarnOf(l.text); ok {
+			pp.nowarn = nw
+		}
+		pp.out = append(pp.out, srcLine{text: l.text, origin: l.origin, call: l.call})
+		return
+	}
+
+	name, rest := parser.SplitHead(text)
+	if m, ok := pp.macros[name]; ok {
+		args := splitArgs(substitute(rest, pp.defines))
+		// 展開されたすべての行に nowarn を適用する
+		nowarn := pp.nowarn
+		start := len(pp.out)
+		pp.expand(m, l, args, depth)
+		if nowarn != "" {
+			for i := start; i < len(pp.out); i++ {
+				pp.out[i].nowarn = nowarn
+			}
+		}
+		pp.nowarn = ""
+		return
+	}
+
+	nowarn := pp.nowarn
+	if nw, ok := nowarnOf(l.text); ok {
+		nowarn = nw
+	}
+	pp.out = append(pp.out, srcLine{
+		text:   substitute(l.text, pp.defines),
+		origin: l.origin,
+		nowarn: nowarn,
+		call:   l.call,
+	})
+	pp.nowarn = ""
+}
+
+// "// nowarn" または "// nowarn unused-label, single-use" の形式.
+// 単独の行なら次の命令に, 命令の後ろに書けばその命令に適用する
+func nowarnOf(s string) (string, bool) {
+	i := strings.Index(s, "//")
+	if i == -1 {
+		return "", false
+	}
+
+	head, rest := parser.SplitHead(s[i+2:])
+	if head != "nowarn" {
+		return "", false
+	}
+	if rest == "" {
+		return "*", true
+	}
+	return rest, true
+}
+
+func (pp *preprocessor) source() []byte {
+	var b bytes.Buffer
+	for _, l := range pp.out {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
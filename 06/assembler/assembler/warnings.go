@@ -0,0 +1,161 @@
+package assembler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	WARN_VAR_OVERFLOW  = "var-overflow"
+	WARN_UNUSED_LABEL  = "unused-label"
+	WARN_SINGLE_USE    = "single-use"
+	WARN_LABEL_AS_ADDR = "label-as-address"
+	WARN_FALL_THROUGH  = "fall-through"
+	WARN_OPT_SKIPPED   = "optimize-skipped"
+)
+
+const screenBase = 16384
+
+type Warning struct {
+	Name   string
+	Line   int
+	Column int
+	Source string
+	Kind   string
+	Msg    string
+}
+
+func (w *Warning) String() string {
+	msg := fmt.Sprintf("warning: %s [%s]", w.Msg, w.Kind)
+	return format(w.Name, w.Line, w.Column, w.Source, msg)
+}
+
+type location struct {
+	origin origin
+	// リストに載せる位置 (srcLine.site)
+	site   origin
+	column int
+	source string
+	nowarn string
+}
+
+func (l location) suppressed(kind string) bool {
+	if l.nowarn == "*" {
+		return true
+	}
+
+	for _, k := range strings.Split(l.nowarn, ",") {
+		if strings.TrimSpace(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *assembler) warnAt(l location, kind string, msg string, args ...any) {
+	if l.suppressed(kind) {
+		return
+	}
+
+	a.prog.Warnings = append(a.prog.Warnings, &Warning{
+		Name:   l.origin.name,
+		Line:   l.origin.line,
+		Column: l.column,
+		Source: l.source,
+		Kind:   kind,
+		Msg:    fmt.Sprintf(msg, args...),
+	})
+}
+
+// 警告にはリンクで付けた接頭辞ではなくソースに書かれた名前を出す
+func (a *assembler) srcName(s string) string {
+	if n, ok := a.srcNames[s]; ok {
+		return n
+	}
+	return s
+}
+
+func (a *assembler) warn() {
+	refs := make(map[string]int)
+	firstRef := make(map[string]location)
+	for i, inst := range a.prog.Instructions {
+		for _, s := range inst.Symbols {
+			if refs[s] == 0 {
+				firstRef[s] = a.locs[i]
+			}
+			refs[s]++
+		}
+	}
+
+	pinned := make(map[string]bool)
+	for _, v := range a.opts.FixedVars {
+		pinned[v.Name] = true
+	}
+	for _, b := range a.prog.Data {
+		pinned[b.Name] = true
+	}
+
+	for _, v := range a.prog.Vars {
+		if pinned[v.Name] {
+			continue
+		}
+
+		loc := firstRef[v.Name]
+		if v.Address >= screenBase {
+			a.warnAt(loc, WARN_VAR_OVERFLOW,
+				"variable %s allocated at %d overlaps SCREEN", v.Name, v.Address)
+		}
+		if refs[v.Name] == 1 {
+			a.warnAt(loc, WARN_SINGLE_USE,
+				"symbol %s is used only once (misspelled label?)", v.Name)
+		}
+	}
+
+	isLabel := make(map[string]bool)
+	for _, l := range a.prog.Labels {
+		isLabel[l.Name] = true
+		if refs[l.Name] == 0 {
+			a.warnAt(a.labelLocs[l.Name], WARN_UNUSED_LABEL,
+				"label %s is defined but never referenced", a.srcName(l.Name))
+		}
+	}
+
+	// ラベルを跨ぐと A の値はわからなくなる
+	lastA := ""
+	for i, inst := range a.prog.Instructions {
+		if len(a.prog.LabelsAt(inst.Address)) > 0 {
+			lastA = ""
+		}
+
+		if inst.Word&0x8000 == 0 {
+			lastA = ""
+			if len(inst.Symbols) == 1 && isLabel[inst.Symbols[0]] {
+				lastA = inst.Symbols[0]
+			}
+			continue
+		}
+
+		readsM := inst.Word&0x1000 != 0
+		writesM := inst.Word&0x0008 != 0
+		if lastA != "" && (readsM || writesM) {
+			a.warnAt(a.locs[i], WARN_LABEL_AS_ADDR,
+				"M is accessed while A holds the ROM address of label %s", a.srcName(lastA))
+		}
+		if inst.Word&0x0020 != 0 {
+			lastA = ""
+		}
+	}
+
+	if n := len(a.prog.Instructions); n > 0 {
+		last := a.prog.Instructions[n-1]
+		if last.Word&0x8000 == 0 || last.Word&0x7 != 0x7 {
+			a.warnAt(a.locs[n-1], WARN_FALL_THROUGH,
+				"program may run past the end of ROM (no terminating loop)")
+		}
+	}
+
+	slices.SortStableFunc(a.prog.Warnings, func(x, y *Warning) int {
+		return a.comparePos(x.Name, x.Line, x.Column, y.Name, y.Line, y.Column)
+	})
+}
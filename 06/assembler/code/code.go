@@ -1,13 +1,15 @@
 package code
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 var dest = map[string]string{
 	"":    "000",
 	"M":   "001",
 	"D":   "010",
 	"DM":  "011",
-	"MD":  "011", // PongL.asm に登場 (可換なんかい)
 	"A":   "100",
 	"AM":  "101",
 	"AD":  "110",
@@ -68,8 +70,51 @@ func code(binMap map[string]string, inst string) func(string) (string, error) {
 	}
 }
 
+func reverse(binMap map[string]string) map[string]string {
+	r := make(map[string]string, len(binMap))
+	for s, bin := range binMap {
+		// 可換な別名 (DM と MD) は辞書順で先のものを採用する
+		if prev, ok := r[bin]; ok && prev < s {
+			continue
+		}
+		r[bin] = s
+	}
+
+	return r
+}
+
+// dest は書き込み先の集合なので, MD や AMD のような並びも A, D, M の順に揃える
+func NormalizeDest(s string) string {
+	var b strings.Builder
+	for _, r := range "ADM" {
+		switch strings.Count(s, string(r)) {
+		case 0:
+		case 1:
+			b.WriteRune(r)
+		default:
+			return s
+		}
+	}
+
+	if b.Len() != len(s) {
+		return s
+	}
+	return b.String()
+}
+
+func Dest(s string) (string, error) {
+	bin, err := code(dest, "dest")(NormalizeDest(s))
+	if err != nil {
+		return "", fmt.Errorf("invalid dest: %s", s)
+	}
+	return bin, nil
+}
+
 var (
-	Dest = code(dest, "dest")
 	Comp = code(comp, "comp")
 	Jump = code(jump, "jump")
+
+	DecodeDest = code(reverse(dest), "dest bits")
+	DecodeComp = code(reverse(comp), "comp bits")
+	DecodeJump = code(reverse(jump), "jump bits")
 )
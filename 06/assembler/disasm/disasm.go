@@ -0,0 +1,173 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"assembler/code"
+	"assembler/symboltable"
+)
+
+type instruction struct {
+	word   uint16
+	isC    bool
+	valid  bool
+	dest   string
+	comp   string
+	jump   string
+	usesM  bool
+	target bool
+}
+
+func ReadHack(r io.Reader) ([]uint16, error) {
+	var words []uint16
+
+	sc := bufio.NewScanner(r)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		w, err := strconv.ParseUint(line, 2, 16)
+		if len(line) != 16 || err != nil {
+			return nil, fmt.Errorf("line %d: invalid word: %s", lineNum, line)
+		}
+		words = append(words, uint16(w))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return words, nil
+}
+
+func decode(w uint16) instruction {
+	inst := instruction{word: w}
+	if w&0x8000 == 0 {
+		inst.valid = true
+		return inst
+	}
+	inst.isC = true
+
+	bin := fmt.Sprintf("%016b", w)
+	if bin[:3] != "111" {
+		return inst
+	}
+
+	comp, err := code.DecodeComp(bin[3:10])
+	if err != nil {
+		return inst
+	}
+	dest, _ := code.DecodeDest(bin[10:13])
+	jump, _ := code.DecodeJump(bin[13:16])
+
+	inst.valid = true
+	inst.comp = comp
+	inst.dest = dest
+	inst.jump = jump
+	inst.usesM = strings.Contains(comp, "M") || strings.Contains(dest, "M")
+	return inst
+}
+
+func (inst instruction) String() string {
+	switch {
+	case !inst.valid:
+		return fmt.Sprintf("(invalid %016b)", inst.word)
+	case !inst.isC:
+		return "@" + strconv.Itoa(int(inst.word))
+	}
+
+	s := inst.comp
+	if inst.dest != "" {
+		s = inst.dest + "=" + s
+	}
+	if inst.jump != "" {
+		s += ";" + inst.jump
+	}
+	return s
+}
+
+// 1 ワード分をシンボルなしで逆アセンブルする
+func Format(w uint16) string {
+	return decode(w).String()
+}
+
+func label(addr int) string {
+	return fmt.Sprintf("L%d", addr)
+}
+
+// R0 と SP のように同じアドレスの別名があれば R 以外を優先する
+func predefined(st *symboltable.SymbolTable, addr int) string {
+	var name string
+	for _, s := range st.Symbols(addr) {
+		if name == "" || (name[0] == 'R' && s[0] != 'R') {
+			name = s
+		}
+	}
+
+	return name
+}
+
+func Disassemble(words []uint16, w io.Writer) (int, error) {
+	insts := make([]instruction, len(words))
+	for i, word := range words {
+		insts[i] = decode(word)
+	}
+
+	labels := make(map[int]bool)
+	for i, inst := range insts {
+		if inst.isC || i+1 >= len(insts) {
+			continue
+		}
+
+		next := insts[i+1]
+		addr := int(inst.word)
+		if next.isC && next.valid && next.jump != "" && addr <= len(insts) {
+			insts[i].target = true
+			labels[addr] = true
+		}
+	}
+
+	st := symboltable.New()
+	bw := bufio.NewWriter(w)
+	invalid := 0
+	for i, inst := range insts {
+		if labels[i] {
+			fmt.Fprintf(bw, "(%s)\n", label(i))
+		}
+
+		switch {
+		// アセンブルし直しても番地がずれないよう値をそのまま置く
+		case !inst.valid:
+			fmt.Fprintf(bw, "    .raw 0b%016b // invalid C-instruction\n", inst.word)
+			invalid++
+
+		case !inst.isC:
+			addr := int(inst.word)
+			operand := strconv.Itoa(addr)
+			if inst.target {
+				operand = label(addr)
+			} else if name := predefined(st, addr); name != "" {
+				next := i+1 < len(insts) && insts[i+1].valid && insts[i+1].usesM
+				if next || addr == 16384 || addr == 24576 {
+					operand = name
+				}
+			}
+			fmt.Fprintf(bw, "    @%s\n", operand)
+
+		default:
+			fmt.Fprintf(bw, "    %s\n", inst.String())
+		}
+	}
+	if labels[len(insts)] {
+		fmt.Fprintf(bw, "(%s)\n", label(len(insts)))
+	}
+
+	return invalid, bw.Flush()
+}
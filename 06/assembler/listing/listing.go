@@ -0,0 +1,66 @@
+package listing
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"assembler/assembler"
+)
+
+func symbolWidth(symbols ...[]assembler.Symbol) int {
+	width := 8
+	for _, ss := range symbols {
+		for _, s := range ss {
+			width = max(width, len(s.Name))
+		}
+	}
+
+	return width
+}
+
+func Write(w io.Writer, prog assembler.Program) error {
+	bw := bufio.NewWriter(w)
+
+	labels := make(map[int]string)
+	labelWidth := len("LABEL")
+	for _, inst := range prog.Instructions {
+		s := strings.Join(prog.LabelsAt(inst.Address), ",")
+		labels[inst.Address] = s
+		labelWidth = max(labelWidth, len(s))
+	}
+
+	// 複数ファイルでも区別できるよう "file:line" で表す
+	lines := make([]string, len(prog.Instructions))
+	lineWidth := len("LINE")
+	for i, inst := range prog.Instructions {
+		lines[i] = fmt.Sprintf("%s:%d", inst.File, inst.LineNum)
+		lineWidth = max(lineWidth, len(lines[i]))
+	}
+
+	fmt.Fprintf(bw, "%5s  %-16s  %-*s  %-*s  %s\n", "ADDR", "WORD", lineWidth, "LINE", labelWidth, "LABEL", "SOURCE")
+	for i, inst := range prog.Instructions {
+		fmt.Fprintf(
+			bw,
+			"%5d  %016b  %-*s  %-*s  %s\n",
+			inst.Address, inst.Word, lineWidth, lines[i],
+			labelWidth, labels[inst.Address],
+			inst.Source,
+		)
+	}
+
+	width := symbolWidth(prog.Labels, prog.Vars)
+
+	fmt.Fprintf(bw, "\nLabels:\n")
+	for _, s := range prog.Labels {
+		fmt.Fprintf(bw, "    %-*s  %5d\n", width, s.Name, s.Address)
+	}
+
+	fmt.Fprintf(bw, "\nVariables:\n")
+	for _, s := range prog.Vars {
+		fmt.Fprintf(bw, "    %-*s  %5d\n", width, s.Name, s.Address)
+	}
+
+	return bw.Flush()
+}
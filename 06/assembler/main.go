@@ -1,112 +1,350 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
-	"assembler/code"
-	"assembler/parser"
-	"assembler/symboltable"
+	"assembler/asmfmt"
+	"assembler/assembler"
+	"assembler/disasm"
+	"assembler/listing"
+	"assembler/output"
+	"assembler/report"
+	"assembler/symfile"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		log.Panic("No file specified")
+func removeExt(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+func create(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
 	}
+	return os.Create(path)
+}
 
-	ipath := os.Args[1]
-	in, err := os.Open(ipath)
+type config struct {
+	opath  string
+	lst    bool
+	sym    bool
+	symIn  string
+	nowarn bool
+	opt    bool
+	report bool
+	format output.Format
+}
+
+func writeAux(opath, ext string, write func(io.Writer) error) {
+	path := removeExt(opath) + ext
+	if opath == "-" {
+		path = "-"
+	}
+
+	f, err := create(path)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer in.Close()
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		log.Panic(err)
+	}
+}
 
-	opath := strings.TrimSuffix(ipath, filepath.Ext(ipath)) + ".hack"
-	out, err := os.Create(opath)
+func readSymbols(path string) symfile.File {
+	f, err := os.Open(path)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer out.Close()
+	defer f.Close()
 
-	st := symboltable.New()
-	w := bufio.NewWriter(out)
+	syms, err := symfile.Read(f)
+	if err != nil {
+		log.Panic(path, ": ", err)
+	}
+	return syms
+}
 
-	p1 := parser.New(in)
-	p1.Advance()
-	for p1.HasMoreLines() {
-		if p1.InstructionType() == parser.L_INSTRUCTION {
-			sb, err := p1.Symbol(nil)
-			if err != nil {
-				log.Panic(err)
+// ディレクトリなら "." でも名前が付くよう絶対パスにしてから拡張子を外す
+func outputBase(path string) string {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+	}
+	return removeExt(path)
+}
+
+func sourceList(path string) ([]string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.IsDir() {
+		return filepath.Glob(filepath.Join(path, "*.asm"))
+	}
+	return []string{path}, nil
+}
+
+func readSources(ipaths []string) ([]assembler.Source, error) {
+	if len(ipaths) == 1 && ipaths[0] == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return []assembler.Source{{Name: "<stdin>", Data: data}}, nil
+	}
+
+	// 先に名前を挙げたファイルがディレクトリ展開で二重に読まれないようにする
+	var srcs []assembler.Source
+	seen := make(map[string]bool)
+	for _, ipath := range ipaths {
+		paths, err := sourceList(ipath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			if seen[filepath.Clean(path)] {
+				continue
 			}
+			seen[filepath.Clean(path)] = true
 
-			if st.Contains(sb) {
-				err := fmt.Errorf("duplicate symbol: %s", sb)
-				log.Panic(err)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
 			}
+			srcs = append(srcs, assembler.Source{Name: path, Data: data})
+		}
+	}
+
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("no .asm files found")
+	}
+	return srcs, nil
+}
+
+func assemble(ipaths []string, cfg config) {
+	srcs, err := readSources(ipaths)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	ipath := filepath.Clean(ipaths[0])
+	opts := assembler.Options{
+		Optimize: cfg.opt,
+	}
+	var syms symfile.File
+	if cfg.symIn != "" {
+		syms = readSymbols(cfg.symIn)
+		opts.FixedLabels = syms.Labels
+		opts.FixedVars = syms.Vars
+	}
+
+	prog, err := assembler.AssembleFiles(srcs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if cfg.opt {
+		before, after := prog.UnoptimizedSize, len(prog.Words)
+		fmt.Fprintf(
+			os.Stderr,
+			"ROM size: %d -> %d words (-%d)\n",
+			before, after, before-after,
+		)
+	}
+
+	if len(prog.Data) > 0 {
+		words := 0
+		for _, b := range prog.Data {
+			words += b.Words
+		}
+		fmt.Fprintf(
+			os.Stderr,
+			"data: %d words in %d block(s), startup routine: %d ROM words\n",
+			words, len(prog.Data), prog.StartupSize,
+		)
+	}
+
+	if cfg.report {
+		if err := report.Write(os.Stderr, prog); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if !cfg.nowarn {
+		for _, w := range prog.Warnings {
+			fmt.Fprintln(os.Stderr, w)
+		}
+	}
 
-			st.AddEntry(sb, p1.LineNum()+1)
+	opath := cfg.opath
+	if opath == "" {
+		opath = "-"
+		if ipath != "-" {
+			opath = outputBase(ipath) + cfg.format.Ext()
 		}
-		p1.Advance()
 	}
-	if _, err := in.Seek(0, 0); err != nil {
+
+	out, err := create(opath)
+	if err != nil {
 		log.Panic(err)
 	}
+	defer out.Close()
 
-	p2 := parser.New(in)
+	if opath != "-" && cfg.format == output.HACK {
+		if err := prog.WriteHack(os.Stdout); err != nil {
+			log.Panic(err)
+		}
+	}
+	if err := output.Write(out, cfg.format, prog.Words); err != nil {
+		log.Panic(err)
+	}
 
-	p2.Advance()
-	for p2.HasMoreLines() {
-		switch p2.InstructionType() {
-		case parser.A_INSTRUCTION:
-			{
-				sb, err := p2.Symbol(st)
-				if err != nil {
-					log.Panic(err)
-				}
+	if cfg.lst {
+		writeAux(opath, ".lst", func(w io.Writer) error {
+			return listing.Write(w, prog)
+		})
+	}
+	if cfg.sym {
+		writeAux(opath, ".sym", func(w io.Writer) error {
+			return symfile.Write(w, symfile.FromProgram(prog, syms))
+		})
+	}
+}
 
-				n, err := strconv.ParseInt(sb, 10, 64)
-				if err != nil {
-					log.Panic(err)
-				}
-				bin := fmt.Sprintf("0%015b\n", n)
+func disassemble(ipath string) {
+	in, err := os.Open(ipath)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer in.Close()
 
-				fmt.Print(bin)
-				// fmt.Printf("%5d: %s", p.Lineno, bin)
-				w.WriteString(bin)
-			}
+	words, err := disasm.ReadHack(in)
+	if err != nil {
+		log.Panic(ipath, ": ", err)
+	}
 
-		case parser.C_INSTRUCTION:
-			{
-				comp, err := code.Comp(p2.Comp())
-				if err != nil {
-					log.Panic(err)
-				}
+	invalid, err := disasm.Disassemble(words, os.Stdout)
+	if err != nil {
+		log.Panic(err)
+	}
+	if invalid > 0 {
+		log.Printf("%s: %d invalid instruction(s)", ipath, invalid)
+	}
+}
 
-				dest, err := code.Dest(p2.Dest())
-				if err != nil {
-					log.Panic(err)
-				}
+func formatSources(args []string) {
+	fs := flag.NewFlagSet("asmfmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to the source file instead of stdout")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	fs.Parse(args)
 
-				jump, err := code.Jump(p2.Jump())
-				if err != nil {
-					log.Panic(err)
-				}
+	if fs.NArg() < 1 {
+		log.Panic("No file specified")
+	}
+	srcs, err := readSources(fs.Args())
+	if err != nil {
+		log.Panic(err)
+	}
 
-				bin := "111" + comp + dest + jump + "\n"
+	status := 0
+	for _, src := range srcs {
+		out, err := asmfmt.Format(src.Data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src.Name, err)
+			status = 1
+			continue
+		}
 
-				fmt.Print(bin)
-				// fmt.Printf("%5d: %s", p.Lineno, bin)
-				w.WriteString(bin)
+		switch {
+		case *diff:
+			changed, err := asmfmt.Diff(os.Stdout, src.Name, src.Data, out)
+			if err != nil {
+				log.Panic(err)
+			}
+			if changed {
+				status = 1
+			}
+
+		case *write:
+			if src.Name == "<stdin>" {
+				log.Panic("cannot use -w with standard input")
+			}
+			if bytes.Equal(src.Data, out) {
+				continue
+			}
+			stat, err := os.Stat(src.Name)
+			if err != nil {
+				log.Panic(err)
+			}
+			if err := os.WriteFile(src.Name, out, stat.Mode().Perm()); err != nil {
+				log.Panic(err)
 			}
+
+		default:
+			os.Stdout.Write(out)
 		}
+	}
+	os.Exit(status)
+}
+
+func main() {
+	var cfg config
+	flag.BoolVar(&cfg.lst, "lst", false, "write a .lst listing file")
+	flag.BoolVar(&cfg.sym, "sym", false, "write a .sym symbol file")
+	flag.StringVar(&cfg.symIn, "import-sym", "", "pre-seed symbols from a .sym file")
+	flag.StringVar(&cfg.opath, "o", "", "output file (- for stdout)")
+	flag.BoolVar(&cfg.nowarn, "nowarn", false, "suppress warnings")
+	flag.BoolVar(&cfg.opt, "O", false, "enable peephole optimization")
+	flag.BoolVar(&cfg.report, "report", false, "print ROM and RAM usage")
+	format := flag.String(
+		"format",
+		string(output.HACK),
+		"output format ("+strings.Join(output.Formats(), ", ")+")",
+	)
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "usage: %s [flags] file.asm|dir ...\n", os.Args[0])
+		fmt.Fprintf(w, "       %s disasm file.hack\n", os.Args[0])
+		fmt.Fprintf(w, "       %s asmfmt [-w|-d] file.asm ...\n\n", os.Args[0])
+		fmt.Fprintln(w, "Files are linked in the order given; the first one is placed at ROM address 0.")
+		fmt.Fprintln(w, "A directory expands to its .asm files in alphabetical order, so list the")
+		fmt.Fprintln(w, "entry file first (e.g. asm Main.asm dir) to choose it explicitly.")
+		fmt.Fprintln(w)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	f, err := output.ParseFormat(*format)
+	if err != nil {
+		log.Panic(err)
+	}
+	cfg.format = f
 
-		p2.Advance()
+	if flag.NArg() < 1 {
+		log.Panic("No file specified")
+	}
+
+	switch flag.Arg(0) {
+	case "disasm":
+		if flag.NArg() < 2 {
+			log.Panic("No file specified")
+		}
+		disassemble(flag.Arg(1))
+	case "asmfmt":
+		formatSources(flag.Args()[1:])
+	default:
+		assemble(flag.Args(), cfg)
 	}
-	w.Flush()
 }
@@ -0,0 +1,156 @@
+package output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+type Format string
+
+const (
+	HACK     = Format("hack")
+	BIN_LE   = Format("bin-le")
+	BIN_BE   = Format("bin-be")
+	IHEX     = Format("ihex")
+	LOGISIM  = Format("logisim")
+	READMEMB = Format("readmemb")
+)
+
+var formats = []Format{HACK, BIN_LE, BIN_BE, IHEX, LOGISIM, READMEMB}
+
+var extensions = map[Format]string{
+	HACK:     ".hack",
+	BIN_LE:   ".bin",
+	BIN_BE:   ".bin",
+	IHEX:     ".hex",
+	LOGISIM:  ".img",
+	READMEMB: ".mem",
+}
+
+func Formats() []string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = string(f)
+	}
+	return names
+}
+
+func ParseFormat(s string) (Format, error) {
+	f := Format(s)
+	if !slices.Contains(formats, f) {
+		return "", fmt.Errorf(
+			"unknown output format: %s (available: %s)",
+			s, strings.Join(Formats(), ", "),
+		)
+	}
+	return f, nil
+}
+
+func (f Format) Ext() string {
+	return extensions[f]
+}
+
+func Write(w io.Writer, f Format, words []uint16) error {
+	bw := bufio.NewWriter(w)
+
+	switch f {
+	case HACK:
+		writeHack(bw, words)
+	case BIN_LE:
+		binary.Write(bw, binary.LittleEndian, words)
+	case BIN_BE:
+		binary.Write(bw, binary.BigEndian, words)
+	case IHEX:
+		writeIHex(bw, words)
+	case LOGISIM:
+		writeLogisim(bw, words)
+	case READMEMB:
+		writeReadmemb(bw, words)
+	default:
+		return fmt.Errorf("unknown output format: %s", f)
+	}
+
+	return bw.Flush()
+}
+
+func writeHack(w io.Writer, words []uint16) {
+	for _, word := range words {
+		fmt.Fprintf(w, "%016b\n", word)
+	}
+}
+
+// アドレスはバイト単位, 各ワードはビッグエンディアンで格納する
+func writeIHex(w io.Writer, words []uint16) {
+	const recordSize = 16
+
+	data := make([]byte, len(words)*2)
+	for i, word := range words {
+		binary.BigEndian.PutUint16(data[i*2:], word)
+	}
+
+	for addr := 0; addr < len(data); addr += recordSize {
+		end := min(addr+recordSize, len(data))
+		writeIHexRecord(w, 0x00, uint16(addr), data[addr:end])
+	}
+	writeIHexRecord(w, 0x01, 0, nil)
+}
+
+func writeIHexRecord(w io.Writer, ty byte, addr uint16, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + ty
+
+	fmt.Fprintf(w, ":%02X%04X%02X", len(data), addr, ty)
+	for _, b := range data {
+		fmt.Fprintf(w, "%02X", b)
+		sum += b
+	}
+	fmt.Fprintf(w, "%02X\n", -sum)
+}
+
+// 同じ値が続く部分は "n*value" に圧縮する
+func writeLogisim(w io.Writer, words []uint16) {
+	const perLine = 8
+	const minRun = 4
+
+	fmt.Fprintln(w, "v2.0 raw")
+
+	n := 0
+	sep := func() {
+		if n > 0 && n%perLine == 0 {
+			fmt.Fprintln(w)
+		} else if n > 0 {
+			fmt.Fprint(w, " ")
+		}
+		n++
+	}
+
+	for i := 0; i < len(words); {
+		j := i
+		for j < len(words) && words[j] == words[i] {
+			j++
+		}
+
+		if j-i >= minRun {
+			sep()
+			fmt.Fprintf(w, "%d*%x", j-i, words[i])
+			i = j
+			continue
+		}
+
+		sep()
+		fmt.Fprintf(w, "%x", words[i])
+		i++
+	}
+	if n > 0 {
+		fmt.Fprintln(w)
+	}
+}
+
+func writeReadmemb(w io.Writer, words []uint16) {
+	fmt.Fprintln(w, "// Hack ROM image for $readmemb")
+	fmt.Fprintln(w, "@0")
+	writeHack(w, words)
+}
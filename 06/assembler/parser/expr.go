@@ -0,0 +1,259 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"assembler/symboltable"
+)
+
+const (
+	maxConstant = 32767
+	maxWord     = 65535
+)
+
+type ExprError struct {
+	Offset int
+	Err    error
+}
+
+func (e *ExprError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExprError) Unwrap() error {
+	return e.Err
+}
+
+type exprParser struct {
+	src string
+	pos int
+	st  *symboltable.SymbolTable
+	max int64
+}
+
+func (ep *exprParser) errorf(pos int, format string, a ...any) error {
+	return &ExprError{Offset: pos, Err: fmt.Errorf(format, a...)}
+}
+
+func (ep *exprParser) skipSpace() {
+	for ep.pos < len(ep.src) && (ep.src[ep.pos] == ' ' || ep.src[ep.pos] == '\t') {
+		ep.pos++
+	}
+}
+
+func (ep *exprParser) peek() byte {
+	ep.skipSpace()
+	if ep.pos >= len(ep.src) {
+		return 0
+	}
+	return ep.src[ep.pos]
+}
+
+// シンボルに使える文字か. head なら先頭の文字として判定する
+func IsSymbolRune(c byte, head bool) bool {
+	switch {
+	case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z':
+		return true
+	case c == '_', c == '.', c == '$', c == ':':
+		return true
+	case '0' <= c && c <= '9':
+		return !head
+	}
+	return false
+}
+
+// expr := term (('+' | '-') term)*
+func (ep *exprParser) expr() (int64, error) {
+	x, err := ep.term()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := ep.peek()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		pos := ep.pos
+		ep.pos++
+
+		y, err := ep.term()
+		if err != nil {
+			return 0, err
+		}
+
+		if op == '+' {
+			x += y
+		} else {
+			x -= y
+		}
+
+		if x > ep.max || x < -ep.max-1 {
+			return 0, ep.errorf(pos, "constant overflow: %d", x)
+		}
+	}
+}
+
+// term := unary (('*' | '/' | '%') unary)*
+func (ep *exprParser) term() (int64, error) {
+	x, err := ep.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := ep.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return x, nil
+		}
+		pos := ep.pos
+		ep.pos++
+
+		y, err := ep.unary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '*':
+			x *= y
+		case '/', '%':
+			if y == 0 {
+				return 0, ep.errorf(pos, "division by zero")
+			}
+			if op == '/' {
+				x /= y
+			} else {
+				x %= y
+			}
+		}
+
+		if x > ep.max || x < -ep.max-1 {
+			return 0, ep.errorf(pos, "constant overflow: %d", x)
+		}
+	}
+}
+
+// unary := '-' unary | primary
+func (ep *exprParser) unary() (int64, error) {
+	if ep.peek() == '-' {
+		ep.pos++
+		x, err := ep.unary()
+		return -x, err
+	}
+
+	return ep.primary()
+}
+
+// primary := number | char | symbol | '(' expr ')'
+func (ep *exprParser) primary() (int64, error) {
+	c := ep.peek()
+	start := ep.pos
+
+	switch {
+	case c == '(':
+		ep.pos++
+		x, err := ep.expr()
+		if err != nil {
+			return 0, err
+		}
+		if ep.peek() != ')' {
+			return 0, ep.errorf(ep.pos, "missing ')'")
+		}
+		ep.pos++
+		return x, nil
+
+	case c == '\'':
+		if ep.pos+2 >= len(ep.src) || ep.src[ep.pos+2] != '\'' {
+			return 0, ep.errorf(start, "invalid character literal")
+		}
+		ch := ep.src[ep.pos+1]
+		if ch < 32 || ch > 126 {
+			return 0, ep.errorf(start, "invalid character literal")
+		}
+		ep.pos += 3
+		return int64(ch), nil
+
+	case '0' <= c && c <= '9':
+		for ep.pos < len(ep.src) && IsSymbolRune(ep.src[ep.pos], false) {
+			ep.pos++
+		}
+		lit := ep.src[start:ep.pos]
+
+		base := 10
+		if len(lit) > 1 && strings.ContainsRune("xXbB", rune(lit[1])) {
+			base = 0
+		}
+
+		n, err := strconv.ParseInt(lit, base, 64)
+		if err != nil {
+			return 0, ep.errorf(start, "invalid constant: %s", lit)
+		}
+		if n > ep.max {
+			return 0, ep.errorf(start, "constant overflow: %s", lit)
+		}
+		return n, nil
+
+	case IsSymbolRune(c, true):
+		for ep.pos < len(ep.src) && IsSymbolRune(ep.src[ep.pos], false) {
+			ep.pos++
+		}
+		name := ep.src[start:ep.pos]
+
+		addr, err := ep.st.GetAddress(name)
+		if err != nil {
+			return 0, ep.errorf(start, "unresolved symbol: %s", name)
+		}
+		return int64(addr), nil
+
+	case c == 0:
+		return 0, ep.errorf(ep.pos, "unexpected end of expression")
+	}
+
+	return 0, ep.errorf(start, "unexpected %q", c)
+}
+
+func evalExpr(s string, st *symboltable.SymbolTable) (int, error) {
+	ep := &exprParser{src: s, st: st, max: maxConstant}
+
+	x, err := ep.expr()
+	if err != nil {
+		return 0, err
+	}
+	if ep.peek() != 0 {
+		return 0, ep.errorf(ep.pos, "unexpected %q", ep.src[ep.pos])
+	}
+
+	if x < 0 || x > maxConstant {
+		return 0, &ExprError{
+			Offset: 0,
+			Err:    fmt.Errorf("value out of range 0..%d: %s = %d", maxConstant, strings.TrimSpace(s), x),
+		}
+	}
+
+	return int(x), nil
+}
+
+// .word の値. 負数と 16 ビット全体 (0x8000..0xFFFF) も書ける
+func EvalWord(s string, st *symboltable.SymbolTable) (int16, error) {
+	ep := &exprParser{src: s, st: st, max: maxWord}
+
+	x, err := ep.expr()
+	if err != nil {
+		return 0, err
+	}
+	if ep.peek() != 0 {
+		return 0, ep.errorf(ep.pos, "unexpected %q", ep.src[ep.pos])
+	}
+
+	if x < -maxConstant-1 || x > maxWord {
+		return 0, &ExprError{
+			Offset: 0,
+			Err:    fmt.Errorf("value out of range %d..%d: %s = %d", -maxConstant-1, maxWord, strings.TrimSpace(s), x),
+		}
+	}
+
+	return int16(uint16(x)), nil
+}
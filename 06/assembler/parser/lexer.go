@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+type TokenType int
+
+const (
+	TK_AT TokenType = iota
+	TK_LPAREN
+	TK_RPAREN
+	TK_EQ
+	TK_SEMI
+	TK_OP
+	TK_IDENT
+	TK_NUMBER
+	TK_CHAR
+)
+
+type Token struct {
+	Type   TokenType
+	Text   string
+	Column int
+}
+
+type SyntaxError struct {
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Msg
+}
+
+// 1 行分をトークンに分割する. "//" 以降はコメントとして読み飛ばす
+func Tokenize(line string) ([]Token, error) {
+	var tokens []Token
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		col := i + 1
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(line[i:], "//"):
+			return tokens, nil
+
+		case c == '@':
+			tokens = append(tokens, Token{TK_AT, "@", col})
+			i++
+		case c == '(':
+			tokens = append(tokens, Token{TK_LPAREN, "(", col})
+			i++
+		case c == ')':
+			tokens = append(tokens, Token{TK_RPAREN, ")", col})
+			i++
+		case c == '=':
+			tokens = append(tokens, Token{TK_EQ, "=", col})
+			i++
+		case c == ';':
+			tokens = append(tokens, Token{TK_SEMI, ";", col})
+			i++
+		case strings.IndexByte("+-!&|*/%", c) != -1:
+			tokens = append(tokens, Token{TK_OP, string(c), col})
+			i++
+
+		case c == '\'':
+			if i+2 >= len(line) || line[i+2] != '\'' {
+				return nil, &SyntaxError{col, "invalid character literal"}
+			}
+			tokens = append(tokens, Token{TK_CHAR, line[i : i+3], col})
+			i += 3
+
+		case IsSymbolRune(c, false):
+			j := i
+			for j < len(line) && IsSymbolRune(line[j], false) {
+				j++
+			}
+			ty := TK_IDENT
+			if !IsSymbolRune(c, true) {
+				ty = TK_NUMBER
+			}
+			tokens = append(tokens, Token{ty, line[i:j], col})
+			i = j
+
+		default:
+			return nil, &SyntaxError{col, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	return tokens, nil
+}
@@ -17,6 +17,8 @@ const (
 	A_INSTRUCTION InstructionType = iota
 	C_INSTRUCTION
 	L_INSTRUCTION
+	// ".raw 0b1000..." で ROM に 16 ビットの値をそのまま置く
+	RAW_INSTRUCTION
 )
 
 func isInt(s string) bool {
@@ -28,6 +30,9 @@ type Parser struct {
 	sc           *bufio.Scanner
 	hasMoreLines bool
 	lineNum      int
+	srcLineNum   int
+	tokens       []Token
+	err          error
 }
 
 func New(r io.Reader) *Parser {
@@ -42,67 +47,234 @@ func (p *Parser) getLine() string {
 	return strings.TrimSpace(p.sc.Text())
 }
 
-func (p *Parser) isBlankLine() bool {
-	return len(p.getLine()) == 0
+func (p *Parser) scan() {
+	p.hasMoreLines = p.sc.Scan()
+	if !p.hasMoreLines {
+		return
+	}
+	p.srcLineNum++
+
+	p.tokens, p.err = Tokenize(p.sc.Text())
+	if p.err == nil && len(p.tokens) > 0 && p.InstructionType() == C_INSTRUCTION {
+		p.err = p.validate()
+	}
 }
 
-func (p *Parser) isComment() bool {
-	return strings.Index(p.getLine(), "//") == 0
+func (p *Parser) validate() error {
+	dest, comp, jump := p.fields()
+	for _, t := range p.tokens {
+		switch {
+		case t.Type == TK_EQ && len(dest) == 0:
+			return &SyntaxError{t.Column, "missing dest"}
+		case t.Type == TK_SEMI && len(jump) == 0:
+			return &SyntaxError{t.Column + 1, "missing jump"}
+		}
+	}
+	if len(comp) == 0 {
+		return &SyntaxError{p.CompColumn(), "missing comp"}
+	}
+
+	return nil
 }
 
+// 空行とコメントだけの行は読み飛ばす. 字句エラーのある行は Err で確認できる
 func (p *Parser) Advance() {
-	p.hasMoreLines = p.sc.Scan()
-	for p.HasMoreLines() && (p.isBlankLine() || p.isComment()) {
-		p.hasMoreLines = p.sc.Scan()
+	p.scan()
+	for p.HasMoreLines() && p.err == nil && len(p.tokens) == 0 {
+		p.scan()
 	}
 
-	if p.HasMoreLines() && p.InstructionType() != L_INSTRUCTION {
+	if p.HasMoreLines() && p.err == nil && p.InstructionType() != L_INSTRUCTION {
 		p.lineNum++
 	}
 }
 
+func (p *Parser) Err() error {
+	return p.err
+}
+
 func (p *Parser) LineNum() int {
 	return p.lineNum
 }
 
+func (p *Parser) SourceLineNum() int {
+	return p.srcLineNum
+}
+
+func (p *Parser) Line() string {
+	return p.getLine()
+}
+
+func (p *Parser) RawLine() string {
+	return p.sc.Text()
+}
+
+func (p *Parser) Tokens() []Token {
+	return p.tokens
+}
+
+func (p *Parser) Column() int {
+	if len(p.tokens) == 0 {
+		return 1
+	}
+	return p.tokens[0].Column
+}
+
+// C 命令を dest = comp ; jump の 3 つに分ける
+func (p *Parser) fields() (dest, comp, jump []Token) {
+	eq, semi := -1, -1
+	for i, t := range p.tokens {
+		if t.Type == TK_EQ && eq == -1 && semi == -1 {
+			eq = i
+		}
+		if t.Type == TK_SEMI && semi == -1 {
+			semi = i
+		}
+	}
+
+	comp = p.tokens
+	if semi != -1 {
+		comp, jump = p.tokens[:semi], p.tokens[semi+1:]
+	}
+	if eq != -1 {
+		dest, comp = comp[:eq], comp[eq+1:]
+	}
+
+	return dest, comp, jump
+}
+
+func join(tokens []Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+func (p *Parser) fieldColumn(tokens []Token, sep TokenType) int {
+	if len(tokens) > 0 {
+		return tokens[0].Column
+	}
+
+	// 空のフィールドは区切り記号の直後を指す
+	for _, t := range p.tokens {
+		if t.Type == sep {
+			return t.Column + 1
+		}
+	}
+	return p.Column()
+}
+
+func (p *Parser) SymbolColumn() int {
+	if len(p.tokens) > 1 {
+		return p.tokens[1].Column
+	}
+	return p.Column() + 1
+}
+
+func (p *Parser) DestColumn() int {
+	return p.Column()
+}
+
+func (p *Parser) CompColumn() int {
+	_, comp, _ := p.fields()
+	return p.fieldColumn(comp, TK_EQ)
+}
+
+func (p *Parser) JumpColumn() int {
+	_, _, jump := p.fields()
+	return p.fieldColumn(jump, TK_SEMI)
+}
+
 func (p *Parser) InstructionType() InstructionType {
-	switch p.getLine()[0] {
-	case '@':
+	switch p.tokens[0].Type {
+	case TK_AT:
 		return A_INSTRUCTION
-	case '(':
+	case TK_LPAREN:
 		return L_INSTRUCTION
+	case TK_IDENT:
+		if p.tokens[0].Text == ".raw" {
+			return RAW_INSTRUCTION
+		}
+		return C_INSTRUCTION
 	default:
 		return C_INSTRUCTION
 	}
 }
 
-func (p *Parser) Symbol(st *symboltable.SymbolTable) (string, error) {
-	var s string
+var symbolRe = regexp.MustCompile(`^[A-Za-z_.$:][0-9A-Za-z_.$:]*$`)
 
-	switch p.InstructionType() {
-	case A_INSTRUCTION:
-		fmt.Sscanf(p.getLine(), "@%s", &s)
-		if isInt(s[0:1]) {
-			if !isInt(s) {
-				return "", fmt.Errorf("invalid symbol: %s", s)
-			}
+func IsSymbol(s string) bool {
+	return symbolRe.MatchString(s)
+}
+
+// 最初の語とそれ以降に分ける (ディレクティブやマクロ呼び出し用)
+func SplitHead(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t")
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+func validSymbol(s string) error {
+	switch {
+	case len(s) == 0:
+		return fmt.Errorf("missing symbol")
+	case isInt(s[0:1]):
+		return fmt.Errorf("symbol starts with a digit: %s", s)
+	case !symbolRe.MatchString(s):
+		return fmt.Errorf("invalid symbol: %s", s)
+	}
+
+	return nil
+}
+
+func Resolve(s string, st *symboltable.SymbolTable) (string, error) {
+	if isInt(s) {
+		return s, nil
+	}
 
-			return s, nil
+	if !symbolRe.MatchString(s) {
+		if len(s) == 0 {
+			return "", fmt.Errorf("missing symbol")
 		}
 
-		if !st.Contains(s) {
-			st.AddVar(s)
+		n, err := evalExpr(s, st)
+		if err != nil {
+			return "", err
 		}
+		return strconv.Itoa(n), nil
+	}
+
+	if !st.Contains(s) {
+		st.AddVar(s)
+	}
 
-		addr, _ := st.GetAddress(s)
-		return strconv.Itoa(addr), nil
+	addr, _ := st.GetAddress(s)
+	return strconv.Itoa(addr), nil
+}
+
+func (p *Parser) Symbol(st *symboltable.SymbolTable) (string, error) {
+	switch p.InstructionType() {
+	case A_INSTRUCTION:
+		return Resolve(p.Operand(), st)
+
+	case RAW_INSTRUCTION:
+		w, err := EvalWord(p.Operand(), st)
+		return strconv.Itoa(int(uint16(w))), err
 
 	case L_INSTRUCTION:
-		re := regexp.MustCompile(`\((.+)\)`)
-		s = re.FindStringSubmatch(p.getLine())[1]
+		n := len(p.tokens)
+		if n < 2 || p.tokens[n-1].Type != TK_RPAREN {
+			return "", fmt.Errorf("malformed label: %s", p.getLine())
+		}
 
-		if isInt(s[0:1]) {
-			return "", fmt.Errorf("invalid symbol: %s", s)
+		raw := p.RawLine()
+		s := strings.TrimSpace(raw[p.tokens[0].Column : p.tokens[n-1].Column-1])
+		if err := validSymbol(s); err != nil {
+			return "", err
 		}
 
 		return s, nil
@@ -111,19 +283,25 @@ func (p *Parser) Symbol(st *symboltable.SymbolTable) (string, error) {
 	return "", nil
 }
 
-func (p *Parser) Dest() string {
-	if p.InstructionType() != C_INSTRUCTION {
+// @ (または .raw) 以降の式をそのままの形で返す (空白は式の評価で読み飛ばす)
+func (p *Parser) Operand() string {
+	ty := p.InstructionType()
+	if (ty != A_INSTRUCTION && ty != RAW_INSTRUCTION) || len(p.tokens) < 2 {
 		return ""
 	}
 
-	t := p.getLine()
-	tail := strings.Index(t, "=")
+	raw := p.RawLine()
+	first, last := p.tokens[1], p.tokens[len(p.tokens)-1]
+	return raw[first.Column-1 : last.Column-1+len(last.Text)]
+}
 
-	if tail == -1 {
+func (p *Parser) Dest() string {
+	if p.InstructionType() != C_INSTRUCTION {
 		return ""
 	}
 
-	return t[:tail]
+	dest, _, _ := p.fields()
+	return join(dest)
 }
 
 func (p *Parser) Comp() string {
@@ -131,14 +309,8 @@ func (p *Parser) Comp() string {
 		return ""
 	}
 
-	t := p.getLine()
-	head := strings.Index(t, "=") + 1
-	tail := strings.Index(t, ";")
-	if tail == -1 {
-		return t[head:]
-	}
-
-	return t[head:tail]
+	_, comp, _ := p.fields()
+	return join(comp)
 }
 
 func (p *Parser) Jump() string {
@@ -146,13 +318,6 @@ func (p *Parser) Jump() string {
 		return ""
 	}
 
-	t := p.getLine()
-	head := strings.Index(t, ";")
-
-	if head == -1 {
-		return ""
-	}
-	head++
-
-	return t[head:]
+	_, _, jump := p.fields()
+	return join(jump)
 }
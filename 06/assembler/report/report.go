@@ -0,0 +1,144 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"assembler/assembler"
+)
+
+const (
+	romSize   = 32768
+	varBase   = 16
+	stackBase = 256
+	heapBase  = 2048
+	screen    = 16384
+)
+
+type Region struct {
+	Label string
+	Start int
+	Size  int
+}
+
+// VM 変換後のコードでは "f$LOOP" や ".EQ.true.000" のような関数内のラベルを
+// 直前の関数に含めるので, 領域の大きさが関数ごとの大きさになる
+func IsLocal(label string) bool {
+	return strings.Contains(label, "$") || strings.HasPrefix(label, ".")
+}
+
+// 番地順に並んだラベルで 0..end を区切り, 番地順に返す.
+// 最初のラベルより前は "(start)" とする
+func Split(labels []assembler.Symbol, end int) []Region {
+	var regions []Region
+	for _, l := range labels {
+		if IsLocal(l.Name) {
+			continue
+		}
+		if n := len(regions); n > 0 && regions[n-1].Start == l.Address {
+			regions[n-1].Label += "," + l.Name
+			continue
+		}
+		regions = append(regions, Region{Label: l.Name, Start: l.Address})
+	}
+
+	if len(regions) == 0 || regions[0].Start > 0 {
+		regions = slices.Insert(regions, 0, Region{Label: "(start)"})
+	}
+
+	for i := range regions {
+		next := end
+		if i+1 < len(regions) {
+			next = regions[i+1].Start
+		}
+		regions[i].Size = next - regions[i].Start
+	}
+	return regions
+}
+
+// ラベルから次のラベルまでの ROM 上の大きさを, 大きい順に返す
+func Regions(prog assembler.Program) []Region {
+	regions := Split(prog.Labels, len(prog.Words))
+	regions = slices.DeleteFunc(regions, func(r Region) bool {
+		return r.Size == 0 && r.Label == "(start)"
+	})
+	slices.SortStableFunc(regions, func(x, y Region) int {
+		return y.Size - x.Size
+	})
+
+	return regions
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+func writeLimit(w io.Writer, name string, limit, top int) {
+	if free := limit - 1 - top; free >= 0 {
+		fmt.Fprintf(w, "    %-22s %5d words free\n", fmt.Sprintf("below %s (%d):", name, limit), free)
+	} else {
+		fmt.Fprintf(w, "    %-22s %5d words over\n", fmt.Sprintf("below %s (%d):", name, limit), -free)
+	}
+}
+
+func Write(w io.Writer, prog assembler.Program) error {
+	bw := bufio.NewWriter(w)
+
+	used := len(prog.Words)
+	fmt.Fprintf(
+		bw, "ROM: %d / %d words (%.1f%%), %d free\n",
+		used, romSize, percent(used, romSize), romSize-used,
+	)
+
+	regions := Regions(prog)
+	width := len("LABEL")
+	for _, r := range regions {
+		width = max(width, len(r.Label))
+	}
+
+	fmt.Fprintf(bw, "\n%-*s  %5s  %5s  %6s\n", width, "LABEL", "START", "SIZE", "%")
+	for _, r := range regions {
+		fmt.Fprintf(
+			bw, "%-*s  %5d  %5d  %5.1f%%\n",
+			width, r.Label, r.Start, r.Size, percent(r.Size, used),
+		)
+	}
+
+	vars, top := 0, varBase-1
+	isData := make(map[string]bool)
+	dataWords := 0
+	for _, b := range prog.Data {
+		isData[b.Name] = true
+		dataWords += b.Words
+		top = max(top, b.Address+b.Words-1)
+	}
+	for _, v := range prog.Vars {
+		if v.Address < varBase || isData[v.Name] {
+			continue
+		}
+		vars++
+		top = max(top, v.Address)
+	}
+
+	fmt.Fprintf(bw, "\nRAM:\n")
+	fmt.Fprintf(bw, "    %-22s %5d\n", "variables:", vars)
+	if len(prog.Data) > 0 {
+		fmt.Fprintf(bw, "    %-22s %5d words in %d block(s)\n", "data:", dataWords, len(prog.Data))
+	}
+	if top < varBase {
+		fmt.Fprintf(bw, "    %-22s %5s\n", "highest address:", "-")
+	} else {
+		fmt.Fprintf(bw, "    %-22s %5d\n", "highest address:", top)
+	}
+	writeLimit(bw, "stack", stackBase, top)
+	writeLimit(bw, "heap", heapBase, top)
+	writeLimit(bw, "SCREEN", screen, top)
+
+	return bw.Flush()
+}
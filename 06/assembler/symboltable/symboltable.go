@@ -1,10 +1,15 @@
 package symboltable
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+)
 
 type SymbolTable struct {
-	table map[string]int
-	tail  int
+	table    map[string]int
+	tail     int
+	vars     []string
+	reserved map[int]bool
 }
 
 func New() *SymbolTable {
@@ -34,7 +39,8 @@ func New() *SymbolTable {
 			"SCREEN": 16384,
 			"KBD":    24576,
 		},
-		tail: 16,
+		tail:     16,
+		reserved: make(map[int]bool),
 	}
 }
 
@@ -42,9 +48,31 @@ func (st *SymbolTable) AddEntry(symbol string, address int) {
 	st.table[symbol] = address
 }
 
+// 番地を固定した変数. AddVar はこの番地を避けて割り当てる
+func (st *SymbolTable) AddFixed(symbol string, address int) {
+	st.table[symbol] = address
+	st.reserved[address] = true
+}
+
+func (st *SymbolTable) Reserve(address int) {
+	st.reserved[address] = true
+}
+
+func (st *SymbolTable) Reserved(address int) bool {
+	return st.reserved[address]
+}
+
 func (st *SymbolTable) AddVar(symbol string) {
+	for st.reserved[st.tail] {
+		st.tail++
+	}
 	st.table[symbol] = st.tail
 	st.tail++
+	st.vars = append(st.vars, symbol)
+}
+
+func (st *SymbolTable) Vars() []string {
+	return st.vars
 }
 
 func (st *SymbolTable) Contains(symbol string) bool {
@@ -60,3 +88,15 @@ func (st *SymbolTable) GetAddress(symbol string) (int, error) {
 
 	return address, nil
 }
+
+func (st *SymbolTable) Symbols(address int) []string {
+	var symbols []string
+	for s, addr := range st.table {
+		if addr == address {
+			symbols = append(symbols, s)
+		}
+	}
+	slices.Sort(symbols)
+
+	return symbols
+}
@@ -0,0 +1,106 @@
+package symfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"assembler/assembler"
+	"assembler/parser"
+)
+
+const (
+	ROM = "ROM"
+	RAM = "RAM"
+)
+
+type File struct {
+	Labels []assembler.Symbol
+	Vars   []assembler.Symbol
+}
+
+// imported は -import-sym で読んだシンボル. 変数は prog.Vars に入っているので,
+// ソースで定義し直されなかったラベルだけを足す
+func FromProgram(prog assembler.Program, imported File) File {
+	defined := make(map[string]bool)
+	for _, s := range prog.Labels {
+		defined[s.Name] = true
+	}
+
+	var labels []assembler.Symbol
+	for _, s := range imported.Labels {
+		if !defined[s.Name] {
+			labels = append(labels, s)
+		}
+	}
+	labels = append(labels, prog.Labels...)
+
+	return File{Labels: labels, Vars: prog.Vars}
+}
+
+// 1 行に "ROM|RAM 番地 名前" を書く
+func Write(w io.Writer, f File) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "// Hack symbol file: kind address name")
+	for _, s := range f.Labels {
+		fmt.Fprintf(bw, "%s %5d %s\n", ROM, s.Address, s.Name)
+	}
+	for _, s := range f.Vars {
+		fmt.Fprintf(bw, "%s %5d %s\n", RAM, s.Address, s.Name)
+	}
+
+	return bw.Flush()
+}
+
+func Read(r io.Reader) (File, error) {
+	var f File
+	seen := make(map[string]bool)
+
+	sc := bufio.NewScanner(r)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return File{}, fmt.Errorf("line %d: expected \"kind address name\"", lineNum)
+		}
+
+		kind, name := fields[0], fields[2]
+		addr, err := strconv.Atoi(fields[1])
+		if err != nil || addr < 0 || addr > 32767 {
+			return File{}, fmt.Errorf("line %d: invalid address: %s", lineNum, fields[1])
+		}
+		if !parser.IsSymbol(name) {
+			return File{}, fmt.Errorf("line %d: invalid symbol: %s", lineNum, name)
+		}
+		if seen[name] {
+			return File{}, fmt.Errorf("line %d: duplicate symbol: %s", lineNum, name)
+		}
+		seen[name] = true
+
+		switch kind {
+		case ROM:
+			f.Labels = append(f.Labels, assembler.Symbol{Name: name, Address: addr})
+		case RAM:
+			f.Vars = append(f.Vars, assembler.Symbol{Name: name, Address: addr})
+		default:
+			return File{}, fmt.Errorf("line %d: unknown kind: %s", lineNum, kind)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return File{}, err
+	}
+
+	return f, nil
+}